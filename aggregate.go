@@ -0,0 +1,81 @@
+package hin
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FieldCount is a row of CountBy.
+type FieldCount[K any] struct {
+	Key   K     `json:"key" bson:"_id"`
+	Count int64 `json:"count" bson:"count"`
+}
+
+// MatchStage returns the $match stage of the criteria, soft delete scope
+// included. Sort, projection and window of the criteria are not applied.
+func MatchStage(filter CriteriaBuilder) (bson.D, error) {
+	// Mgo sets the error of an invalid expression
+	match := filter.Mgo()
+	if filter.Error != nil {
+		return nil, filter.Error
+	}
+	return bson.D{{Key: "$match", Value: match}}, nil
+}
+
+// Aggregate runs the pipeline after the $match of filter and decodes the
+// output documents into R. Like BaseRepo, it checks the criteria fields
+// against T in strict mode and only reads the documents of the tenant of ctx
+// when T has a TenantID.
+//
+//	stats, r := hin.Aggregate[Stat](ctx, dao, hin.Where("status").Eq("paid"),
+//		bson.D{{Key: "$group", Value: bson.M{"_id": "$user_id", "total": bson.M{"$sum": "$amount"}}}})
+func Aggregate[R any, T any](ctx context.Context, dao BaseDAO[T], filter CriteriaBuilder, pipeline ...bson.D) ([]R, *MDR) {
+	if err := checkCriteria[T](filter); err != nil {
+		return nil, newErrMDR(err)
+	}
+	filter, err := scopeTenant[T](ctx, filter)
+	if err != nil {
+		return nil, newErrMDR(err)
+	}
+	match, err := MatchStage(filter)
+	if err != nil {
+		return nil, newErrMDR(err)
+	}
+
+	stages := append(mongo.Pipeline{match}, pipeline...)
+	results := make([]R, 0)
+	if dr := dao.Aggregate(ctx, stages, &results); dr.Error != nil {
+		return nil, dr
+	}
+	return results, new(MDR).SetCount(int64(len(results)))
+}
+
+// CountBy counts the documents matching filter per value of field, the most
+// frequent value first.
+func CountBy[K any, T any](ctx context.Context, dao BaseDAO[T], filter CriteriaBuilder, field string) ([]FieldCount[K], *MDR) {
+	return Aggregate[FieldCount[K]](ctx, dao, filter,
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$" + mgoField(field), "count": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	)
+}
+
+// Distinct returns the distinct values of field among the documents matching
+// filter in ascending order.
+func Distinct[V any, T any](ctx context.Context, dao BaseDAO[T], filter CriteriaBuilder, field string) ([]V, *MDR) {
+	rows, dr := Aggregate[struct {
+		Value V `bson:"_id"`
+	}](ctx, dao, filter,
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$" + mgoField(field)}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	)
+	if dr.Error != nil {
+		return nil, dr
+	}
+
+	values := make([]V, 0, len(rows))
+	for _, r := range rows {
+		values = append(values, r.Value)
+	}
+	return values, dr
+}
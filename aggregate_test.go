@@ -0,0 +1,57 @@
+package hin
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	dao := &testDAO[BaseModel]{}
+
+	if _, dr := CountBy[string](ctx, dao, Where("id").In("a", "b").WithDeleted(), "status"); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	want := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": []any{"a", "b"}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	if !reflect.DeepEqual(dao.pipeline, want) {
+		t.Errorf("CountBy pipeline = %v, want %v", dao.pipeline, want)
+	}
+
+	if _, dr := Distinct[string](ctx, dao, CriteriaBuilder{}, "id"); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	p := dao.pipeline.(mongo.Pipeline)
+	if !reflect.DeepEqual(p[0], bson.D{{Key: "$match", Value: bson.M{"deleted_at": nil}}}) {
+		t.Errorf("Distinct match = %v", p[0])
+	}
+	if !reflect.DeepEqual(p[1], bson.D{{Key: "$group", Value: bson.M{"_id": "$_id"}}}) {
+		t.Errorf("Distinct group = %v", p[1])
+	}
+
+	if _, dr := Aggregate[bson.M](ctx, dao, Criteria("a = ?")); dr.Error == nil {
+		t.Error("a criteria error must fail the aggregation")
+	}
+
+	// a hand built expression without the values of its operator
+	invalid := CriteriaBuilder{Expr: &CriteriaExpr{Op: CriteriaBetween, Field: "created_at", Value: 5}}
+	if match, err := MatchStage(invalid); err == nil {
+		t.Errorf("MatchStage() = %v, want an error", match)
+	}
+	if _, dr := Aggregate[bson.M](ctx, dao, invalid); dr.Error == nil {
+		t.Error("an invalid expression must fail the aggregation")
+	}
+
+	viper.Set("criteria.strict", true)
+	defer viper.Set("criteria.strict", nil)
+	if _, dr := Aggregate[bson.M](ctx, dao, Where("nickname").Eq("ann")); dr.Error == nil {
+		t.Error("strict mode must reject a field the model does not store")
+	}
+}
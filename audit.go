@@ -0,0 +1,217 @@
+package hin
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// AuditModel is embedded inline next to BaseModel by models that record who
+// wrote them, BaseRepo fills the fields with the actor of the context.
+type AuditModel struct {
+	CreatedBy string `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	UpdatedBy string `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	DeletedBy string `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor sets the actor of writes made with ctx, for jobs and consumers
+// that run outside of a request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf returns the actor set by WithActor, or else the subject of the JWT
+// claims of the current request.
+func ActorOf(ctx context.Context) string {
+	if v, ok := ctx.Value(actorKey{}).(string); ok {
+		return v
+	}
+	if c := GetCurrentContext(ctx); c != nil && c.Claims != nil {
+		return c.Claims.Subject
+	}
+	return ""
+}
+
+// setAudit sets the string field name of the model rv points to, if any.
+func setAudit(rv reflect.Value, name string, actor string) {
+	if actor == "" {
+		return
+	}
+	if v := rv.Elem().FieldByName(name); v.IsValid() && v.Kind() == reflect.String {
+		v.SetString(actor)
+	}
+}
+
+func hasField[M any](name string) bool {
+	var m M
+	t := reflect.TypeOf(m)
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := t.FieldByName(name)
+	return ok
+}
+
+type HistoryAction string
+
+const (
+	HistoryCreate  HistoryAction = "create"
+	HistoryUpdate  HistoryAction = "update"
+	HistoryRemove  HistoryAction = "remove"
+	HistoryRestore HistoryAction = "restore"
+	HistoryDelete  HistoryAction = "delete"
+)
+
+// FieldChange is a field of a model that changed, nested documents are
+// compared field by field under dotted names.
+type FieldChange struct {
+	Field string `json:"field" bson:"field"`
+	Old   any    `json:"old,omitempty" bson:"old,omitempty"`
+	New   any    `json:"new,omitempty" bson:"new,omitempty"`
+}
+
+type HistoryRecord struct {
+	ID        string        `json:"id" bson:"_id,minsize"`
+	EntityID  string        `json:"entity_id" bson:"entity_id"`
+	Action    HistoryAction `json:"action" bson:"action"`
+	Actor     string        `json:"actor,omitempty" bson:"actor,omitempty"`
+	TenantID  string        `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Changes   []FieldChange `json:"changes" bson:"changes"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// History stores the changes of one collection, see BaseRepo.WithHistory.
+// Give every collection its own dao, e.g. a users_history table. Without a
+// Client the history is not written in the transaction of the change.
+type History struct {
+	Dao    BaseDAO[HistoryRecord]
+	Client *mongo.Client
+	// Ignore lists fields left out of the changes.
+	Ignore []string
+}
+
+func NewHistory(dao BaseDAO[HistoryRecord], client *mongo.Client) *History {
+	return &History{
+		dao,
+		client,
+		[]string{"_id", "updated_at", "updated_by", "version"},
+	}
+}
+
+// Of returns the history of an entity, oldest first.
+func (h *History) Of(ctx context.Context, entityID string) ([]HistoryRecord, *MDR) {
+	filter := h.scope(ctx, Where("entity_id").Eq(entityID).OrderBy("created_at", "_id"))
+	return h.Dao.Find(ctx, filter.Mgo(), filter.FindOptions()...)
+}
+
+func (h *History) Paging(ctx context.Context, filter CriteriaBuilder, paging PagingQuery) ([]HistoryRecord, int64, *MDR) {
+	if filter.Error != nil {
+		return nil, 0, newErrMDR(filter.Error)
+	}
+	filter = h.scope(ctx, filter)
+	return h.Dao.Paging(ctx, filter.Mgo(), paging, filter.FindOptions()...)
+}
+
+// scope keeps the records of the tenant of ctx, without a tenant only those of
+// models that have none. AllTenants reads every record.
+func (h *History) scope(ctx context.Context, filter CriteriaBuilder) CriteriaBuilder {
+	if IsAllTenants(ctx) {
+		return filter
+	}
+	if t := TenantOf(ctx); t != "" {
+		return filter.And(Where("tenant_id").Eq(t))
+	}
+	return filter.And(Where("tenant_id").IsNull())
+}
+
+func (h *History) CreateIndexes(ctx context.Context) *MDR {
+	return h.Dao.CreateIndexes(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+}
+
+// Record stores the change of an entity from before to after, either may be
+// nil. Nothing is stored when an update changed no field.
+func (h *History) Record(ctx context.Context, action HistoryAction, entityID string, before, after any) error {
+	old, err := toBsonM(before)
+	if err != nil {
+		return err
+	}
+	cur, err := toBsonM(after)
+	if err != nil {
+		return err
+	}
+
+	changes := h.diff(old, cur)
+	if len(changes) == 0 && action == HistoryUpdate {
+		return nil
+	}
+	tenant, _ := cur["tenant_id"].(string)
+	if t, ok := old["tenant_id"].(string); ok && tenant == "" {
+		tenant = t
+	}
+	return h.Dao.Insert(ctx, HistoryRecord{
+		ID:        NewID().String(),
+		EntityID:  entityID,
+		Action:    action,
+		Actor:     ActorOf(ctx),
+		TenantID:  tenant,
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+func (h *History) diff(old, cur bson.M) []FieldChange {
+	a, b := bson.M{}, bson.M{}
+	flattenDoc(a, "", old)
+	flattenDoc(b, "", cur)
+	for _, f := range h.Ignore {
+		delete(a, f)
+		delete(b, f)
+	}
+
+	var fields []string
+	for k := range a {
+		fields = append(fields, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]FieldChange, 0)
+	for _, f := range fields {
+		if ov, nv := a[f], b[f]; !reflect.DeepEqual(ov, nv) {
+			changes = append(changes, FieldChange{f, ov, nv})
+		}
+	}
+	return changes
+}
+
+// flattenDoc copies the fields of doc to dst under dotted paths, arrays are
+// kept as a whole.
+func flattenDoc(dst bson.M, prefix string, doc bson.M) {
+	for k, v := range doc {
+		if sub, ok := v.(bson.M); ok && len(sub) > 0 {
+			flattenDoc(dst, prefix+k+".", sub)
+			continue
+		}
+		if v != nil {
+			dst[prefix+k] = v
+		}
+	}
+}
+
+func (h *History) transaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	if h == nil || h.Client == nil {
+		return fn(ctx)
+	}
+	return WithTransaction(ctx, h.Client, fn)
+}
@@ -0,0 +1,100 @@
+package hin
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+type auditModel struct {
+	BaseModel  `bson:",inline"`
+	AuditModel `bson:",inline"`
+	Name       string  `bson:"name"`
+	Address    address `bson:"address"`
+}
+
+type address struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip"`
+}
+
+type auditEntity struct {
+	ID        HID
+	Name      string
+	Address   address
+	CreatedAt time.Time
+	CreatedBy string
+	Version   int64
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[auditModel]()
+	history := NewHistory(NewMemoryDAO[HistoryRecord](), nil)
+	repo := NewBaseRepository[auditModel, auditEntity](dao, nil).WithHistory(history)
+
+	RegisterContext("req-1", &CurrentContext{Claims: &JwtClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "ann"}}})
+	defer UnRegisterContext("req-1")
+	annCtx := context.WithValue(ctx, headerXRequestID, "req-1")
+
+	dr := repo.Save(annCtx, auditEntity{Name: "shop", Address: address{"Paris", "75001"}})
+	if dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	id := dr.ID()
+
+	e, _ := repo.FindOne(ctx, Where("_id").Eq(id))
+	e.Name, e.Address.City = "store", "Lyon"
+	if dr := repo.Save(WithActor(ctx, "bob"), e); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	if dr := repo.Remove(WithActor(ctx, "cat"), Where("_id").Eq(id)); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+
+	m, _ := dao.FindOne(ctx, map[string]any{"_id": id})
+	if m.CreatedBy != "ann" || m.UpdatedBy != "bob" || m.DeletedBy != "cat" {
+		t.Errorf("audit fields = %+v", m.AuditModel)
+	}
+
+	if dr := repo.Restore(ctx, Where("_id").Eq(id)); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	if dr := repo.HardRemove(WithActor(ctx, "dan"), Where("_id").Eq(id)); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+
+	records, dr := history.Of(ctx, id)
+	if dr.Error != nil || len(records) != 5 {
+		t.Fatalf("Of() = %+v, %v", records, dr.Error)
+	}
+	want := []struct {
+		action HistoryAction
+		actor  string
+	}{{HistoryCreate, "ann"}, {HistoryUpdate, "bob"}, {HistoryRemove, "cat"}, {HistoryRestore, ""}, {HistoryDelete, "dan"}}
+	for i, w := range want {
+		if records[i].Action != w.action || records[i].Actor != w.actor {
+			t.Errorf("record %d = %s by %q", i, records[i].Action, records[i].Actor)
+		}
+	}
+
+	update := records[1].Changes
+	if len(update) != 2 || update[0].Field != "address.city" || update[0].Old != "Paris" || update[0].New != "Lyon" ||
+		update[1].Field != "name" || update[1].New != "store" {
+		t.Errorf("update changes = %+v", update)
+	}
+	if removed := records[2].Changes; len(removed) != 2 || removed[0].Field != "deleted_at" || removed[1].New != "cat" {
+		t.Errorf("remove changes = %+v", removed)
+	}
+	if deleted := records[4].Changes; len(deleted) == 0 || deleted[0].New != nil {
+		t.Errorf("delete changes = %+v", deleted)
+	}
+
+	// the update of a missing entity is not recorded
+	repo.Save(ctx, auditEntity{ID: e.ID, Name: "x"})
+	n, _ := history.Dao.Count(ctx, nil)
+	if n != 5 {
+		t.Errorf("history of a missing entity = %d records", n)
+	}
+}
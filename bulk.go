@@ -0,0 +1,199 @@
+package hin
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BulkOpType int
+
+const (
+	BulkInsert BulkOpType = iota
+	BulkUpdate
+	BulkUpsert
+	BulkDelete
+)
+
+// ErrBulkNotExecuted is the error of the operations an ordered bulk write
+// skipped after a failure.
+var ErrBulkNotExecuted = errors.New("bulk: operation not executed")
+
+// BulkOp is one operation of BaseDAO.BulkWrite. Model is the document of an
+// insert and the fields set by an update or upsert, Many applies an update or
+// a delete to every match instead of the first one.
+type BulkOp struct {
+	Type   BulkOpType
+	Filter any
+	Model  any
+	Many   bool
+}
+
+func InsertOp(model any) BulkOp {
+	return BulkOp{Type: BulkInsert, Model: model}
+}
+
+func UpdateOp(filter any, model any) BulkOp {
+	return BulkOp{Type: BulkUpdate, Filter: filter, Model: model}
+}
+
+func UpsertOp(filter any, model any) BulkOp {
+	return BulkOp{Type: BulkUpsert, Filter: filter, Model: model}
+}
+
+func DeleteOp(filter any) BulkOp {
+	return BulkOp{Type: BulkDelete, Filter: filter}
+}
+
+// All makes an update or a delete apply to every matching document.
+func (o BulkOp) All() BulkOp {
+	o.Many = true
+	return o
+}
+
+// BulkOpResult is the outcome of one operation, ID is set for inserts and
+// upserts that inserted.
+type BulkOpResult struct {
+	Index int
+	OK    bool
+	ID    string
+	Error error
+}
+
+// mgoWriteModels converts the operations, inserts without an _id get one so
+// that their ids can be reported.
+func mgoWriteModels(ops []BulkOp) ([]mongo.WriteModel, []string, error) {
+	models := make([]mongo.WriteModel, 0, len(ops))
+	ids := make([]string, len(ops))
+
+	for i, op := range ops {
+		switch op.Type {
+		case BulkInsert:
+			doc, id, err := bulkInsertDoc(op.Model)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bulk: operation %d: %w", i, err)
+			}
+			ids[i] = id
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		case BulkUpdate, BulkUpsert:
+			update := bson.M{"$set": op.Model}
+			upsert := op.Type == BulkUpsert
+			if op.Many {
+				models = append(models, mongo.NewUpdateManyModel().SetFilter(op.Filter).SetUpdate(update).SetUpsert(upsert))
+			} else {
+				models = append(models, mongo.NewUpdateOneModel().SetFilter(op.Filter).SetUpdate(update).SetUpsert(upsert))
+			}
+		case BulkDelete:
+			if op.Many {
+				models = append(models, mongo.NewDeleteManyModel().SetFilter(op.Filter))
+			} else {
+				models = append(models, mongo.NewDeleteOneModel().SetFilter(op.Filter))
+			}
+		default:
+			return nil, nil, fmt.Errorf("bulk: operation %d: unknown type %d", i, op.Type)
+		}
+	}
+	return models, ids, nil
+}
+
+func bulkInsertDoc(model any) (bson.D, string, error) {
+	b, err := bson.Marshal(model)
+	if err != nil {
+		return nil, "", err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return nil, "", err
+	}
+
+	for i, e := range doc {
+		if e.Key != "_id" {
+			continue
+		}
+		if id := bulkID(e.Value); id != "" {
+			return doc, id, nil
+		}
+		doc = append(doc[:i], doc[i+1:]...)
+		break
+	}
+
+	id := NewID().String()
+	return append(bson.D{{Key: "_id", Value: id}}, doc...), id, nil
+}
+
+func bulkID(v any) string {
+	switch id := v.(type) {
+	case string:
+		return id
+	case primitive.ObjectID:
+		return id.Hex()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// bulkResult reports the driver result per operation. The server only
+// returns totals for matched, modified and deleted documents, so those are
+// reported on the MDR.
+func bulkResult(ops []BulkOp, ids []string, ordered bool, r *mongo.BulkWriteResult, err error) *MDR {
+	mdr := &MDR{Ops: make([]BulkOpResult, len(ops))}
+	for i := range ops {
+		mdr.Ops[i] = BulkOpResult{Index: i, OK: true}
+	}
+
+	var bwe mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bwe) {
+		for i := range mdr.Ops {
+			mdr.Ops[i].OK = false
+			mdr.Ops[i].Error = err
+		}
+		mdr.Error = err
+		return mdr
+	}
+
+	first := len(ops)
+	for _, we := range bwe.WriteErrors {
+		if we.Index < len(ops) {
+			mdr.Ops[we.Index].OK = false
+			mdr.Ops[we.Index].Error = we
+			first = min(first, we.Index)
+		}
+	}
+	if len(bwe.WriteErrors) > 0 || bwe.WriteConcernError != nil {
+		mdr.Error = bwe
+	}
+	if ordered {
+		for i := first + 1; i < len(ops); i++ {
+			mdr.Ops[i].OK = false
+			mdr.Ops[i].Error = ErrBulkNotExecuted
+		}
+	}
+
+	for i, op := range ops {
+		if op.Type == BulkInsert && mdr.Ops[i].OK {
+			mdr.Ops[i].ID = ids[i]
+		}
+	}
+	if r != nil {
+		for i, id := range r.UpsertedIDs {
+			if int(i) < len(ops) {
+				mdr.Ops[i].ID = bulkID(id)
+			}
+		}
+		mdr.Inserted = r.InsertedCount
+		mdr.Matched = r.MatchedCount
+		mdr.Modified = r.ModifiedCount
+		mdr.Upserted = r.UpsertedCount
+		mdr.Deleted = r.DeletedCount
+		mdr.Count = r.InsertedCount + r.ModifiedCount + r.UpsertedCount + r.DeletedCount
+	}
+	for _, op := range mdr.Ops {
+		if op.ID != "" {
+			mdr.IDs = append(mdr.IDs, op.ID)
+		}
+	}
+	return mdr
+}
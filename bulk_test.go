@@ -0,0 +1,64 @@
+package hin
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestBulkWriteModels(t *testing.T) {
+	ops := []BulkOp{
+		InsertOp(BaseModel{ID: "given"}),
+		InsertOp(BaseModel{}),
+		UpdateOp(bson.M{"_id": "a"}, bson.M{"name": "x"}).All(),
+		UpsertOp(bson.M{"_id": "b"}, bson.M{"name": "y"}),
+		DeleteOp(bson.M{"_id": "c"}),
+	}
+
+	models, ids, err := mgoWriteModels(ops)
+	if err != nil || len(models) != len(ops) {
+		t.Fatalf("mgoWriteModels() = %d models, %v", len(models), err)
+	}
+	if ids[0] != "given" || len(ids[1]) != 20 {
+		t.Errorf("insert ids = %q", ids)
+	}
+	if _, ok := models[2].(*mongo.UpdateManyModel); !ok {
+		t.Errorf("All() update = %T", models[2])
+	}
+	if m, ok := models[3].(*mongo.UpdateOneModel); !ok || m.Upsert == nil || !*m.Upsert {
+		t.Errorf("upsert = %#v", models[3])
+	}
+
+	if _, _, err := mgoWriteModels([]BulkOp{{Type: 42}}); err == nil {
+		t.Error("unknown operation type must fail")
+	}
+}
+
+func TestBulkResult(t *testing.T) {
+	ops := []BulkOp{InsertOp(nil), InsertOp(nil), UpsertOp(nil, nil), DeleteOp(nil)}
+	ids := []string{"i0", "i1", "", ""}
+	bwe := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}},
+	}}
+	r := &mongo.BulkWriteResult{InsertedCount: 1, UpsertedCount: 1, DeletedCount: 1, UpsertedIDs: map[int64]any{2: "u2"}}
+
+	mdr := bulkResult(ops, ids, false, r, bwe)
+	if mdr.Error == nil || mdr.Count != 3 || mdr.Inserted != 1 || mdr.Deleted != 1 {
+		t.Fatalf("unordered result = %+v", mdr)
+	}
+	want := []bool{true, false, true, true}
+	for i, op := range mdr.Ops {
+		if op.OK != want[i] {
+			t.Errorf("op %d ok = %v, error %v", i, op.OK, op.Error)
+		}
+	}
+	if len(mdr.IDs) != 2 || mdr.IDs[0] != "i0" || mdr.IDs[1] != "u2" {
+		t.Errorf("ids = %v", mdr.IDs)
+	}
+
+	mdr = bulkResult(ops, ids, true, &mongo.BulkWriteResult{InsertedCount: 1}, bwe)
+	if mdr.Ops[0].OK == false || !errors.Is(mdr.Ops[2].Error, ErrBulkNotExecuted) || !errors.Is(mdr.Ops[3].Error, ErrBulkNotExecuted) {
+		t.Errorf("ordered result = %+v", mdr.Ops)
+	}
+}
@@ -0,0 +1,357 @@
+package hin
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache is a byte cache shaped like Redis GET, SET with expiry, DEL and
+// INCR, so a Redis client can back it with a thin adapter. A ttl of zero
+// keeps the value until it is evicted.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+// CachedRepository caches FindOne, Find and Count of a repository by their
+// criteria. Save, Remove, Restore and HardRemove move the generation of the
+// namespace on, which invalidates every cached read of the repository at
+// once, also on other instances sharing the cache. Reads inside a
+// transaction bypass the cache, they may see uncommitted writes. Entities are
+// cached with encoding/gob, so a hit holds the exported fields of a miss
+// whatever their json tags.
+type CachedRepository[E any] struct {
+	BaseRepository[E]
+	Cache       Cache
+	Logger      *Logger
+	Namespace   string
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+func NewCachedRepository[E any](
+	repo BaseRepository[E],
+	cache Cache,
+	logger *Logger,
+	namespace string,
+) *CachedRepository[E] {
+	return &CachedRepository[E]{
+		repo,
+		cache,
+		logger,
+		namespace,
+		time.Minute,
+		10 * time.Second,
+	}
+}
+
+func (r *CachedRepository[E]) WithTTL(ttl time.Duration) *CachedRepository[E] {
+	r.TTL = ttl
+	return r
+}
+
+// WithNegativeTTL caches FindOne misses for ttl, zero disables it.
+func (r *CachedRepository[E]) WithNegativeTTL(ttl time.Duration) *CachedRepository[E] {
+	r.NegativeTTL = ttl
+	return r
+}
+
+type cacheEntry struct {
+	Value []byte
+	Miss  bool
+}
+
+func (r *CachedRepository[E]) FindOne(ctx context.Context, filter CriteriaBuilder) (E, *MDR) {
+	var e E
+	key, ok := r.key(ctx, "one", filter)
+	if ok {
+		if entry, ok := r.get(ctx, key); ok {
+			if entry.Miss {
+				return e, newErrMDR(mongo.ErrNoDocuments)
+			}
+			if err := gobDecode(entry.Value, &e); err == nil {
+				return e, new(MDR).SetCount(1)
+			}
+		}
+	}
+
+	e, dr := r.BaseRepository.FindOne(ctx, filter)
+	if !ok {
+		return e, dr
+	}
+	switch {
+	case dr.Error == nil:
+		r.set(ctx, key, &e, r.TTL)
+	case errors.Is(dr.Error, mongo.ErrNoDocuments) && r.NegativeTTL > 0:
+		r.setEntry(ctx, key, cacheEntry{Miss: true}, r.NegativeTTL)
+	}
+	return e, dr
+}
+
+func (r *CachedRepository[E]) Find(ctx context.Context, filter CriteriaBuilder) ([]E, *MDR) {
+	key, ok := r.key(ctx, "find", filter)
+	if ok {
+		if entry, ok := r.get(ctx, key); ok {
+			var es []E
+			if err := gobDecode(entry.Value, &es); err == nil {
+				return es, new(MDR).SetCount(int64(len(es)))
+			}
+		}
+	}
+
+	es, dr := r.BaseRepository.Find(ctx, filter)
+	if ok && dr.Error == nil {
+		r.set(ctx, key, es, r.TTL)
+	}
+	return es, dr
+}
+
+func (r *CachedRepository[E]) Count(ctx context.Context, filter CriteriaBuilder) int64 {
+	key, ok := r.key(ctx, "count", filter)
+	if ok {
+		if entry, ok := r.get(ctx, key); ok {
+			var n int64
+			if err := gobDecode(entry.Value, &n); err == nil {
+				return n
+			}
+		}
+	}
+
+	n := r.BaseRepository.Count(ctx, filter)
+	if ok {
+		r.set(ctx, key, n, r.TTL)
+	}
+	return n
+}
+
+func (r *CachedRepository[E]) Exist(ctx context.Context, filter CriteriaBuilder) bool {
+	_, dr := r.FindOne(ctx, filter)
+	return dr.Count > 0
+}
+
+func (r *CachedRepository[E]) Save(ctx context.Context, entity E) *MDR {
+	defer r.Invalidate(ctx)
+	return r.BaseRepository.Save(ctx, entity)
+}
+
+func (r *CachedRepository[E]) Remove(ctx context.Context, filter CriteriaBuilder) *MDR {
+	defer r.Invalidate(ctx)
+	return r.BaseRepository.Remove(ctx, filter)
+}
+
+func (r *CachedRepository[E]) Restore(ctx context.Context, filter CriteriaBuilder) *MDR {
+	defer r.Invalidate(ctx)
+	return r.BaseRepository.Restore(ctx, filter)
+}
+
+func (r *CachedRepository[E]) HardRemove(ctx context.Context, filter CriteriaBuilder) *MDR {
+	defer r.Invalidate(ctx)
+	return r.BaseRepository.HardRemove(ctx, filter)
+}
+
+// Invalidate drops every cached read of the namespace, call it after writes
+// that do not go through the repository. Inside a transaction it waits for
+// the commit, a read cached before would otherwise outlive the write.
+func (r *CachedRepository[E]) Invalidate(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	AfterCommit(ctx, func() {
+		if _, err := r.Cache.Incr(ctx, r.Namespace+":gen"); err != nil {
+			r.Logger.Error("cachedRepository.Invalidate", zap.Error(err))
+		}
+	})
+}
+
+// key hashes the tenant and the JSON form of the criteria under the current
+// generation, ok is false when the read must not be cached.
+func (r *CachedRepository[E]) key(ctx context.Context, op string, filter CriteriaBuilder) (string, bool) {
+	if filter.Error != nil || InTransaction(ctx) {
+		return "", false
+	}
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return "", false
+	}
+
+	gen := []byte("0")
+	if v, ok, err := r.Cache.Get(ctx, r.Namespace+":gen"); err != nil {
+		r.Logger.Warn("cachedRepository.key", zap.Error(err))
+		return "", false
+	} else if ok {
+		gen = v
+	}
+
+	tenant := TenantOf(ctx)
+	if IsAllTenants(ctx) {
+		tenant = "*"
+	}
+	sum := sha1.Sum(append([]byte(tenant+"\x00"), b...))
+	return r.Namespace + ":" + string(gen) + ":" + op + ":" + hex.EncodeToString(sum[:]), true
+}
+
+func (r *CachedRepository[E]) get(ctx context.Context, key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	b, ok, err := r.Cache.Get(ctx, key)
+	if err != nil {
+		r.Logger.Warn("cachedRepository.get", zap.Error(err))
+		return entry, false
+	}
+	if !ok || gobDecode(b, &entry) != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (r *CachedRepository[E]) set(ctx context.Context, key string, v any, ttl time.Duration) {
+	b, err := gobEncode(v)
+	if err != nil {
+		r.Logger.Warn("cachedRepository.set", zap.Error(err))
+		return
+	}
+	r.setEntry(ctx, key, cacheEntry{Value: b}, ttl)
+}
+
+func (r *CachedRepository[E]) setEntry(ctx context.Context, key string, entry cacheEntry, ttl time.Duration) {
+	b, err := gobEncode(entry)
+	if err == nil {
+		err = r.Cache.Set(ctx, key, b, ttl)
+	}
+	if err != nil {
+		r.Logger.Warn("cachedRepository.set", zap.Error(err))
+	}
+}
+
+func gobEncode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobDecode(b []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// LRUCache is an in-process Cache holding at most Size entries, the least
+// recently used entry is evicted first. Counters of Incr are kept apart and
+// never evicted, a lost generation would bring stale reads back.
+type LRUCache struct {
+	Size int
+
+	mux      sync.Mutex
+	ll       *list.List
+	items    map[string]*list.Element
+	counters map[string]int64
+}
+
+type lruItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		Size:     size,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		counters: map[string]int64{},
+	}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if n, ok := c.counters[key]; ok {
+		return []byte(strconv.FormatInt(n, 10)), true, nil
+	}
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := el.Value.(*lruItem)
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(el)
+	return item.value, true, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.counters, key)
+	c.set(key, value, ttl)
+	return nil
+}
+
+func (c *LRUCache) set(key string, value []byte, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		item := el.Value.(*lruItem)
+		item.value, item.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem{key: key, value: value, expires: expires})
+	for c.Size > 0 && c.ll.Len() > c.Size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LRUCache) Delete(ctx context.Context, keys ...string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, k := range keys {
+		delete(c.counters, k)
+		if el, ok := c.items[k]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+func (c *LRUCache) Incr(ctx context.Context, key string) (int64, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	n, ok := c.counters[key]
+	if el, found := c.items[key]; !ok && found {
+		// a value written by Set becomes a counter
+		var err error
+		if n, err = strconv.ParseInt(string(el.Value.(*lruItem).value), 10, 64); err != nil {
+			return 0, errors.New("cache: value is not an integer")
+		}
+		c.remove(el)
+	}
+	n++
+	c.counters[key] = n
+	return n, nil
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruItem).key)
+}
@@ -0,0 +1,217 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[memoryUserModel]()
+	base := NewBaseRepository[memoryUserModel, memoryUser](dao, &Logger{zap.NewNop()})
+	repo := NewCachedRepository[memoryUser](base, NewLRUCache(100), &Logger{zap.NewNop()}, "users")
+
+	for _, name := range []string{"ann", "bob"} {
+		if dr := repo.Save(ctx, memoryUser{Name: name, Role: "user"}); dr.Error != nil {
+			t.Fatal(dr.Error)
+		}
+	}
+
+	ann, dr := repo.FindOne(ctx, Where("name").Eq("ann"))
+	if dr.Error != nil || ann.Name != "ann" {
+		t.Fatalf("FindOne() = %+v, %v", ann, dr.Error)
+	}
+	if n := repo.Count(ctx, Where("role").Eq("user")); n != 2 {
+		t.Fatalf("Count() = %d", n)
+	}
+	if _, dr := repo.FindOne(ctx, Where("name").Eq("cat")); !errors.Is(dr.Error, mongo.ErrNoDocuments) {
+		t.Fatalf("FindOne(cat) = %v", dr.Error)
+	}
+
+	// writes behind the repository are not seen until it is invalidated
+	dao.Insert(ctx, memoryUserModel{BaseModel: BaseModel{ID: NewID().String()}, Name: "cat", Role: "user"})
+	if cached, _ := repo.FindOne(ctx, Where("name").Eq("ann")); cached.ID != ann.ID {
+		t.Errorf("cached FindOne() = %+v", cached)
+	}
+	if n := repo.Count(ctx, Where("role").Eq("user")); n != 2 {
+		t.Errorf("cached Count() = %d", n)
+	}
+	if _, dr := repo.FindOne(ctx, Where("name").Eq("cat")); !errors.Is(dr.Error, mongo.ErrNoDocuments) {
+		t.Errorf("negative cache = %v", dr.Error)
+	}
+
+	repo.Invalidate(ctx)
+	if users, _ := repo.Find(ctx, Where("role").Eq("user").OrderBy("name")); len(users) != 3 || users[2].Name != "cat" {
+		t.Errorf("Find() = %+v", users)
+	}
+
+	// Save and Remove invalidate the cached reads
+	ann.Role = "admin"
+	if dr := repo.Save(ctx, ann); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	if users, _ := repo.Find(ctx, Where("role").Eq("user").OrderBy("name")); len(users) != 2 {
+		t.Errorf("Find() after Save = %+v", users)
+	}
+	if dr := repo.Remove(ctx, Where("name").Eq("bob")); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	if repo.Exist(ctx, Where("name").Eq("bob")) {
+		t.Error("bob is still cached after Remove")
+	}
+
+	// a zero negative ttl does not cache misses
+	repo.WithNegativeTTL(0).Invalidate(ctx)
+	repo.FindOne(ctx, Where("name").Eq("dan"))
+	dao.Insert(ctx, memoryUserModel{BaseModel: BaseModel{ID: NewID().String()}, Name: "dan"})
+	if !repo.Exist(ctx, Where("name").Eq("dan")) {
+		t.Error("miss cached with a zero negative ttl")
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2)
+
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), 0)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("b should be evicted as least recently used")
+	}
+	if v, ok, _ := c.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %s, %v", v, ok)
+	}
+
+	c.Set(ctx, "t", []byte("x"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok, _ := c.Get(ctx, "t"); ok {
+		t.Error("t should be expired")
+	}
+
+	if n, _ := c.Incr(ctx, "n"); n != 1 {
+		t.Errorf("Incr() = %d", n)
+	}
+	if n, _ := c.Incr(ctx, "n"); n != 2 {
+		t.Errorf("Incr() = %d", n)
+	}
+	if _, err := c.Incr(ctx, "a"); err != nil {
+		t.Errorf("Incr(a) = %v", err)
+	}
+	// counters are not evicted
+	for _, k := range []string{"x", "y", "z"} {
+		c.Set(ctx, k, []byte(k), 0)
+	}
+	if v, ok, _ := c.Get(ctx, "n"); !ok || string(v) != "2" {
+		t.Errorf("Get(n) after evictions = %s, %v", v, ok)
+	}
+	c.Delete(ctx, "n")
+	if _, ok, _ := c.Get(ctx, "n"); ok {
+		t.Error("n should be deleted")
+	}
+}
+
+func TestCachedRepositoryTransaction(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("invalidate after commit", func(mt *mtest.T) {
+		// hello of a replica set member, which supports transactions
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "setName", Value: "rs"}))
+		ctx := context.Background()
+		cache := NewLRUCache(100)
+		base := NewBaseRepository[memoryUserModel, memoryUser](NewMemoryDAO[memoryUserModel](), &Logger{zap.NewNop()})
+		repo := NewCachedRepository[memoryUser](base, cache, &Logger{zap.NewNop()}, "users")
+
+		err := WithTransaction(ctx, mt.Client, func(txCtx context.Context) error {
+			if dr := repo.Save(txCtx, memoryUser{Name: "ann"}); dr.Error != nil {
+				return dr.Error
+			}
+			if _, ok, _ := cache.Get(ctx, "users:gen"); ok {
+				mt.Error("Invalidate ran before the commit")
+			}
+			return nil
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if gen, _, _ := cache.Get(ctx, "users:gen"); string(gen) != "1" {
+			mt.Errorf("generation after commit = %s", gen)
+		}
+
+		// an aborted transaction does not invalidate
+		boom := errors.New("boom")
+		err = WithTransaction(ctx, mt.Client, func(txCtx context.Context) error {
+			repo.Save(txCtx, memoryUser{Name: "bob"})
+			return boom
+		})
+		if gen, _, _ := cache.Get(ctx, "users:gen"); err != boom || string(gen) != "1" {
+			mt.Errorf("generation after abort = %s, %v", gen, err)
+		}
+	})
+}
+
+type cacheUserModel struct {
+	BaseModel `bson:",inline"`
+	Name      string   `bson:"name"`
+	Password  string   `bson:"password"`
+	Tags      []string `bson:"tags"`
+}
+
+type cacheUser struct {
+	ID        HID
+	Name      string
+	Password  string `json:"-"`
+	Tags      []string
+	CreatedAt time.Time
+}
+
+func TestCachedRepositoryHit(t *testing.T) {
+	ctx := context.Background()
+	base := NewBaseRepository[cacheUserModel, cacheUser](NewMemoryDAO[cacheUserModel](), &Logger{zap.NewNop()})
+	repo := NewCachedRepository[cacheUser](base, NewLRUCache(100), &Logger{zap.NewNop()}, "users")
+
+	if dr := repo.Save(ctx, cacheUser{Name: "ann", Password: "secret", Tags: []string{"a", "b"}}); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+
+	// the first read misses and fills the cache, the second one hits it
+	miss, _ := repo.FindOne(ctx, Where("name").Eq("ann"))
+	hit, dr := repo.FindOne(ctx, Where("name").Eq("ann"))
+	if dr.Error != nil || miss.Password != "secret" {
+		t.Fatalf("FindOne() = %+v, %v", miss, dr.Error)
+	}
+	equalFields(t, miss, hit)
+
+	misses, _ := repo.Find(ctx, Where("name").Eq("ann"))
+	hits, _ := repo.Find(ctx, Where("name").Eq("ann"))
+	if len(misses) != 1 || len(hits) != 1 {
+		t.Fatalf("Find() = %+v, %+v", misses, hits)
+	}
+	equalFields(t, misses[0], hits[0])
+}
+
+func equalFields(t *testing.T, want, got cacheUser) {
+	t.Helper()
+	wv, gv := reflect.ValueOf(want), reflect.ValueOf(got)
+	for i := 0; i < wv.NumField(); i++ {
+		w, g := wv.Field(i).Interface(), gv.Field(i).Interface()
+		if wt, ok := w.(time.Time); ok {
+			if !wt.Equal(g.(time.Time)) {
+				t.Errorf("%s = %v, want %v", wv.Type().Field(i).Name, g, w)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(w, g) {
+			t.Errorf("%s = %v, want %v", wv.Type().Field(i).Name, g, w)
+		}
+	}
+}
@@ -2,20 +2,135 @@ package hin
 
 import (
 	"errors"
+	"fmt"
 	"github.com/samber/lo"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	CriteriaAnd  = "and"
+	CriteriaOr   = "or"
+	CriteriaNot  = "not"
+	CriteriaEq   = "eq"
+	CriteriaNe   = "ne"
+	CriteriaLike = "like"
+	CriteriaIn   = "in"
+
+	CriteriaGt      = "gt"
+	CriteriaGte     = "gte"
+	CriteriaLt      = "lt"
+	CriteriaLte     = "lte"
+	CriteriaBetween = "between"
+	CriteriaNin     = "nin"
+	CriteriaNull    = "null"
+	CriteriaNotNull = "notnull"
+	CriteriaExists  = "exists"
+
+	// like and contains match the value anywhere in the field, prefix and
+	// suffix anchor it. The value is always matched literally, the i variants
+	// ignore case. regex is the explicit opt-in to a raw regular expression.
+	CriteriaContains  = "contains"
+	CriteriaPrefix    = "prefix"
+	CriteriaSuffix    = "suffix"
+	CriteriaILike     = "ilike"
+	CriteriaIContains = "icontains"
+	CriteriaIPrefix   = "iprefix"
+	CriteriaISuffix   = "isuffix"
+	CriteriaRegex     = "regex"
+)
+
+// criteriaOps maps the operator spellings accepted by struct tags and the
+// string syntax to the canonical operator names.
+var criteriaOps = map[string]string{
+	"=":       CriteriaEq,
+	"eq":      CriteriaEq,
+	"!=":      CriteriaNe,
+	"<>":      CriteriaNe,
+	"ne":      CriteriaNe,
+	">":       CriteriaGt,
+	"gt":      CriteriaGt,
+	">=":      CriteriaGte,
+	"gte":     CriteriaGte,
+	"<":       CriteriaLt,
+	"lt":      CriteriaLt,
+	"<=":      CriteriaLte,
+	"lte":     CriteriaLte,
+	"like":    CriteriaLike,
+	"in":      CriteriaIn,
+	"nin":     CriteriaNin,
+	"between": CriteriaBetween,
+	"null":    CriteriaNull,
+	"notnull": CriteriaNotNull,
+	"exists":  CriteriaExists,
+
+	"contains":  CriteriaContains,
+	"prefix":    CriteriaPrefix,
+	"suffix":    CriteriaSuffix,
+	"ilike":     CriteriaILike,
+	"icontains": CriteriaIContains,
+	"iprefix":   CriteriaIPrefix,
+	"isuffix":   CriteriaISuffix,
+	"regex":     CriteriaRegex,
+}
+
+// isPatternOp reports whether op is one of the escaped string match operators.
+func isPatternOp(op string) bool {
+	switch op {
+	case CriteriaLike, CriteriaContains, CriteriaPrefix, CriteriaSuffix,
+		CriteriaILike, CriteriaIContains, CriteriaIPrefix, CriteriaISuffix:
+		return true
+	}
+	return false
+}
+
+// CriteriaExpr is a node of the criteria syntax tree. Logical nodes (and, or,
+// not) hold their operands in Exprs, conditions hold Field, Op and Value.
+type CriteriaExpr struct {
+	Op    string
+	Field string
+	Value any
+	Exprs []*CriteriaExpr
+}
+
 type CriteriaBuilder struct {
 	Query any
 	SQL   string
 	Vars  []any
+	Expr  *CriteriaExpr
 	Error error
+
+	Orders []SortField
+	Fields []string
+	Size   int64
+	Offset int64
+	Scope  DeletedScope
 }
 
+// DeletedScope decides how soft deleted documents take part in a query.
+type DeletedScope int
+
+const (
+	// ScopeDefault excludes soft deleted documents.
+	ScopeDefault DeletedScope = iota
+	// ScopeWithDeleted includes soft deleted documents.
+	ScopeWithDeleted
+	// ScopeOnlyDeleted selects soft deleted documents only, e.g. for a recycle bin.
+	ScopeOnlyDeleted
+)
+
 func Criteria(query any, args ...any) CriteriaBuilder {
+	if b, ok := query.(CriteriaBuilder); ok && len(args) == 0 {
+		return b
+	}
+
 	builder := CriteriaBuilder{
 		Query: query,
 		Vars:  args,
@@ -24,156 +139,418 @@ func Criteria(query any, args ...any) CriteriaBuilder {
 	if s, ok := query.(string); ok {
 		builder.SQL = s
 
-		if strings.Count(s, "?") != len(args) {
+		if strings.TrimSpace(s) != "" {
+			builder.Expr, builder.Error = parseCriteria(s, args...)
+		} else if len(args) > 0 {
 			builder.Error = errors.New("the number of args is not equal to given query")
 		}
+	} else {
+		builder.Expr, builder.Error = entityCriteria(query)
 	}
 
 	return builder
 }
 
+// CriteriaStrict reports whether criteria are checked strictly: unknown tag
+// operators, unsupported field types and fields missing on the model of the
+// repository are errors instead of falling back to equality or being ignored.
+// It is read from criteria.strict and defaults to on in the dev and test envs
+// only, an unset env is lenient like prod.
+func CriteriaStrict() bool {
+	if viper.IsSet("criteria.strict") {
+		return viper.GetBool("criteria.strict")
+	}
+	switch viper.GetString("env") {
+	case "dev", "test":
+		return true
+	}
+	return false
+}
+
+// OrderBy appends sort keys, a leading "-" sorts the field descending,
+// e.g. OrderBy("-priority", "created_at").
+func (c CriteriaBuilder) OrderBy(fields ...string) CriteriaBuilder {
+	c.Orders = append(append([]SortField{}, c.Orders...), parseSortFields(fields...)...)
+	return c
+}
+
+// Select restricts the returned documents to the given fields.
+func (c CriteriaBuilder) Select(fields ...string) CriteriaBuilder {
+	c.Fields = append(append([]string{}, c.Fields...), fields...)
+	return c
+}
+
+func (c CriteriaBuilder) Limit(n int64) CriteriaBuilder {
+	c.Size = n
+	return c
+}
+
+func (c CriteriaBuilder) Skip(n int64) CriteriaBuilder {
+	c.Offset = n
+	return c
+}
+
+func (c CriteriaBuilder) WithDeleted() CriteriaBuilder {
+	c.Scope = ScopeWithDeleted
+	return c
+}
+
+func (c CriteriaBuilder) OnlyDeleted() CriteriaBuilder {
+	c.Scope = ScopeOnlyDeleted
+	return c
+}
+
+// FindOptions returns the sort, projection and window carried by the builder.
+func (c CriteriaBuilder) FindOptions() []FindOption {
+	var opts []FindOption
+	if orders := c.Orders; len(orders) > 0 {
+		opts = append(opts, func(o *FindOptions) {
+			o.Sort = append(o.Sort, orders...)
+		})
+	}
+	if len(c.Fields) > 0 {
+		opts = append(opts, WithProjection(c.Fields...))
+	}
+	if c.Size > 0 {
+		opts = append(opts, WithLimit(c.Size))
+	}
+	if c.Offset > 0 {
+		opts = append(opts, WithSkip(c.Offset))
+	}
+	return opts
+}
+
 func (c *CriteriaBuilder) Mgo() bson.M {
 	if c.Error != nil {
 		return nil
 	}
 
-	if c.SQL != "" {
-		return buildMgoSql(c.SQL, c.Vars...)
+	bm := bson.M{}
+	if c.Expr != nil {
+		// a hand built expression may not hold the values its operator needs
+		if err := c.Expr.Validate(); err != nil {
+			c.Error = err
+			return nil
+		}
+		bm = c.Expr.mgo()
 	}
 
-	return buildMgoEntity(c.Query)
+	switch c.Scope {
+	case ScopeWithDeleted:
+	case ScopeOnlyDeleted:
+		if _, ok := bm["deleted_at"]; ok {
+			return bson.M{"$and": []bson.M{bm, {"deleted_at": bson.M{"$ne": nil}}}}
+		}
+		bm["deleted_at"] = bson.M{"$ne": nil}
+	default:
+		// an explicit condition on deleted_at takes over the default scope
+		if _, ok := bm["deleted_at"]; !ok {
+			bm["deleted_at"] = nil
+		}
+	}
+	return bm
 }
 
-func buildMgoSql(sql string, args ...any) bson.M {
-	bm := bson.M{
-		"deleted_at": nil,
+func (e *CriteriaExpr) mgo() bson.M {
+	switch e.Op {
+	case CriteriaAnd:
+		return mgoAnd(e.Exprs)
+	case CriteriaOr:
+		var or []bson.M
+		for _, x := range e.Exprs {
+			// flatten (a OR b) OR c
+			if m := x.mgo(); x.Op == CriteriaOr {
+				or = append(or, m["$or"].([]bson.M)...)
+			} else {
+				or = append(or, m)
+			}
+		}
+		return bson.M{"$or": or}
+	case CriteriaNot:
+		return bson.M{"$nor": []bson.M{e.Exprs[0].mgo()}}
 	}
 
-	vc := 0
-	for si, syntax := range []string{" AND ", " OR "} {
-		if si > 0 && !strings.Contains(sql, syntax) {
-			continue
+	k := mgoField(e.Field)
+	switch e.Op {
+	case CriteriaEq:
+		return bson.M{k: e.Value}
+	case CriteriaNe:
+		return bson.M{k: bson.M{"$ne": e.Value}}
+	case CriteriaLike, CriteriaContains, CriteriaPrefix, CriteriaSuffix,
+		CriteriaILike, CriteriaIContains, CriteriaIPrefix, CriteriaISuffix:
+		return bson.M{k: mgoPattern(e.Op, e.Value)}
+	case CriteriaRegex:
+		return bson.M{k: bson.M{"$regex": e.Value}}
+	case CriteriaIn:
+		return bson.M{k: bson.M{"$in": e.Value}}
+	case CriteriaNin:
+		return bson.M{k: bson.M{"$nin": e.Value}}
+	case CriteriaGt:
+		return bson.M{k: bson.M{"$gt": e.Value}}
+	case CriteriaGte:
+		return bson.M{k: bson.M{"$gte": e.Value}}
+	case CriteriaLt:
+		return bson.M{k: bson.M{"$lt": e.Value}}
+	case CriteriaLte:
+		return bson.M{k: bson.M{"$lte": e.Value}}
+	case CriteriaBetween:
+		r, ok := e.Value.([]any)
+		if !ok || len(r) != 2 {
+			return bson.M{}
 		}
+		return bson.M{k: bson.M{"$gte": r[0], "$lte": r[1]}}
+	case CriteriaNull:
+		return bson.M{k: nil}
+	case CriteriaNotNull:
+		return bson.M{k: bson.M{"$ne": nil}}
+	case CriteriaExists:
+		return bson.M{k: bson.M{"$exists": e.Value}}
+	}
 
-		_bm := bson.M{}
-		for _, sep := range strings.Split(sql, syntax) {
-			q := strings.Split(sep, " ")
-			if len(q) > 3 {
-				continue
-			}
+	return bson.M{}
+}
 
-			k := q[0]
-			var v any
+// mgoPattern compiles the string match operators to an escaped $regex, so
+// user input such as ".*" or "(a+)+" is matched literally.
+func mgoPattern(op string, value any) bson.M {
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprint(value)
+	}
+	pattern := regexp.QuoteMeta(s)
 
-			if k == "id" && !strings.Contains(sql, "_id") {
-				k = "_id"
-			}
+	switch op {
+	case CriteriaPrefix, CriteriaIPrefix:
+		pattern = "^" + pattern
+	case CriteriaSuffix, CriteriaISuffix:
+		pattern = pattern + "$"
+	}
 
-			if "?" == q[2] {
-				v = args[vc]
-				vc++
-			} else {
-				v = q[2]
-			}
+	switch op {
+	case CriteriaILike, CriteriaIContains, CriteriaIPrefix, CriteriaISuffix:
+		return bson.M{"$regex": pattern, "$options": "i"}
+	}
+	return bson.M{"$regex": pattern}
+}
 
-			switch q[1] {
-			case "=":
-				_bm[k] = v
-			case "!=":
-				_bm[k] = bson.M{"$ne": v}
-			case "like":
-				_bm[k] = bson.M{"$regex": v}
-			case "in":
-				_bm[k] = bson.M{"$in": v}
+// mgoAnd merges the operands into a single document when their keys do not
+// overlap, and falls back to $and otherwise. Operator documents on the same
+// field are combined, so gte and lte on one field form a single range.
+func mgoAnd(exprs []*CriteriaExpr) bson.M {
+	bm := bson.M{}
+	docs := make([]bson.M, 0, len(exprs))
+	merge := true
+
+	for _, x := range exprs {
+		m := x.mgo()
+		docs = append(docs, m)
+		for k, v := range m {
+			if prev, ok := bm[k]; ok {
+				if combined, ok := mgoMergeOps(prev, v); ok {
+					bm[k] = combined
+					continue
+				}
+				merge = false
 			}
+			bm[k] = v
 		}
+	}
 
-		switch syntax {
-		case " AND ":
-			bm = lo.Assign(bm, _bm)
-		case " OR ":
-			var _ob []bson.M
-			for k, v := range _bm {
-				_ob = append(_ob, bson.M{k: v})
-			}
-			bm["$or"] = _ob
+	if merge {
+		return bm
+	}
+	return bson.M{"$and": docs}
+}
+
+func mgoMergeOps(a, b any) (bson.M, bool) {
+	am, ok := a.(bson.M)
+	if !ok || !isMgoOps(am) {
+		return nil, false
+	}
+	bm, ok := b.(bson.M)
+	if !ok || !isMgoOps(bm) {
+		return nil, false
+	}
+
+	m := bson.M{}
+	for k, v := range am {
+		m[k] = v
+	}
+	for k, v := range bm {
+		if _, ok := m[k]; ok {
+			return nil, false
 		}
+		m[k] = v
 	}
-	return bm
+	return m, true
 }
 
-func buildMgoEntity(entity any) bson.M {
-	bm := bson.M{
-		"deleted_at": nil,
+func isMgoOps(m bson.M) bool {
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
 	}
+	return len(m) > 0
+}
 
-	if entity == nil || entity == "" {
-		return bm
+func mgoField(field string) string {
+	if field == "id" {
+		return "_id"
+	}
+	return field
+}
+
+// entityCriteria builds the criteria of a query struct. Each non-zero field
+// becomes a condition, the `criteria:"op,key"` tag selects the operator and
+// overrides the snake_cased field name. Zero fields are skipped unless tagged
+// with `nil` (matches null) or `empty` (matches the zero value).
+func entityCriteria(entity any) (*CriteriaExpr, error) {
+	if entity == nil {
+		return nil, nil
 	}
 
 	v := reflect.ValueOf(entity)
-	for i := 0; i < v.NumField(); i++ {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("criteria: unsupported query type %T", entity)
+	}
 
+	strict := CriteriaStrict()
+	var exprs []*CriteriaExpr
+	for i := 0; i < v.NumField(); i++ {
 		field := v.Type().Field(i)
-		tag := field.Tag
+		if !field.IsExported() {
+			continue
+		}
 
-		label := tag.Get("criteria")
+		label := field.Tag.Get("criteria")
 		value := v.Field(i)
 		key := toSnake(field.Name)
 
-		if label == "-" || !value.IsValid() || value.IsZero() {
-			if strings.HasPrefix(label, "nil") {
-				bm[key] = nil
-			}
-			if strings.HasPrefix(label, "empty") {
-				bm[key] = value.Interface()
-			}
+		lv := strings.Split(label, ",")
+		if len(lv) > 1 && lv[1] != "" {
+			key = lv[1]
+		}
+
+		if label == "-" {
 			continue
 		}
 
-		if label == "" {
-			bm[key] = value.Interface()
+		op, ok := criteriaOps[lv[0]]
+		if !ok {
+			if strict && lv[0] != "" && lv[0] != "nil" && lv[0] != "empty" {
+				return nil, fmt.Errorf("criteria: unknown operator %q on field %s", lv[0], field.Name)
+			}
+			op = CriteriaEq
+		}
+
+		if !value.IsValid() || value.IsZero() {
+			if lv[0] == "nil" {
+				exprs = append(exprs, &CriteriaExpr{Op: CriteriaNull, Field: key})
+			}
+			if lv[0] == "empty" {
+				exprs = append(exprs, &CriteriaExpr{Op: CriteriaEq, Field: key, Value: value.Interface()})
+			}
 			continue
 		}
 
-		lv := strings.Split(label, ",")
-		if len(lv) > 1 {
-			key = lv[1]
+		if strict && !isCriteriaType(field.Type) {
+			return nil, fmt.Errorf("criteria: unsupported type %s of field %s", field.Type, field.Name)
 		}
 
-		switch lv[0] {
-		case "=":
-		case "eq":
-			bm[key] = value.Interface()
-		case "!=":
-		case "ne":
-			bm[key] = bson.M{"$ne": value.Interface()}
-		case "like":
-			bm[key] = bson.M{"$regex": value.Interface()}
-		case "in":
-			bm[key] = bson.M{"$in": value.Interface()}
-		default:
-			bm[key] = value.Interface()
+		expr, err := entityCondition(op, key, value)
+		if err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			exprs = append(exprs, expr)
 		}
 	}
 
-	special := map[string]string{
-		"id": "_id",
+	switch len(exprs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return exprs[0], nil
 	}
-skl:
-	for sk, sv := range special {
-		for k, _ := range bm {
-			if k == sv {
-				continue skl
-			}
+	return &CriteriaExpr{Op: CriteriaAnd, Exprs: exprs}, nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	hidType      = reflect.TypeOf(HID{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// isCriteriaType reports whether values of t can be compared by a condition:
+// scalars, times and ids, pointers to them and lists of them.
+func isCriteriaType(t reflect.Type) bool {
+	switch t {
+	case timeType, hidType, objectIDType:
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return isCriteriaType(t.Elem())
+	}
+	return false
+}
+
+func entityCondition(op string, key string, value reflect.Value) (*CriteriaExpr, error) {
+	switch op {
+	case CriteriaBetween:
+		if (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) || value.Len() != 2 {
+			return nil, fmt.Errorf("criteria: between on %s requires a slice of two bounds", key)
 		}
-		if v := bm[sk]; v != nil {
-			bm[sv] = bm[sk]
-			delete(bm, sk)
+		// a nil bound is open and degrades to a one sided range, zero is a
+		// bound like any other, `Score [2]*int criteria:"between"`
+		lo, hi := value.Index(0), value.Index(1)
+		switch {
+		case isNilBound(lo) && isNilBound(hi):
+			return nil, nil
+		case isNilBound(lo):
+			return &CriteriaExpr{Op: CriteriaLte, Field: key, Value: boundValue(hi)}, nil
+		case isNilBound(hi):
+			return &CriteriaExpr{Op: CriteriaGte, Field: key, Value: boundValue(lo)}, nil
 		}
+		return &CriteriaExpr{Op: op, Field: key, Value: []any{boundValue(lo), boundValue(hi)}}, nil
+	case CriteriaNull, CriteriaNotNull:
+		// a bool field toggles the check, `IsDeleted bool criteria:"notnull,deleted_at"`
+		if value.Kind() == reflect.Bool && !value.Bool() {
+			return nil, nil
+		}
+		return &CriteriaExpr{Op: op, Field: key}, nil
+	case CriteriaExists:
+		if value.Kind() != reflect.Bool {
+			return nil, fmt.Errorf("criteria: exists on %s requires a bool field", key)
+		}
+		return &CriteriaExpr{Op: op, Field: key, Value: value.Bool()}, nil
 	}
 
-	return bm
+	return &CriteriaExpr{Op: op, Field: key, Value: value.Interface()}, nil
+}
+
+func isNilBound(v reflect.Value) bool {
+	return (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()
+}
+
+func boundValue(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v.Interface()
 }
 
 func toSnake(s string) string {
@@ -189,3 +566,106 @@ func toSnake(s string) string {
 	}
 	return strings.ToLower(string(ret))
 }
+
+// CheckFields returns an error when the criteria refers to a field, in a
+// condition, the sort or the projection, that is not stored by model.
+func (c *CriteriaBuilder) CheckFields(model any) error {
+	t := reflect.TypeOf(model)
+
+	var paths []string
+	var walk func(e *CriteriaExpr)
+	walk = func(e *CriteriaExpr) {
+		if e == nil {
+			return
+		}
+		if e.Field != "" {
+			paths = append(paths, e.Field)
+		}
+		for _, x := range e.Exprs {
+			walk(x)
+		}
+	}
+	walk(c.Expr)
+	for _, s := range c.Orders {
+		paths = append(paths, s.Field)
+	}
+	paths = append(paths, c.Fields...)
+
+	for _, p := range paths {
+		if !hasFieldPath(t, strings.Split(mgoField(p), ".")) {
+			return fmt.Errorf("criteria: field %s does not exist on %s", p, t)
+		}
+	}
+	return nil
+}
+
+var bsonFieldsCache sync.Map
+
+// bsonFields maps the bson keys of struct t to their field types, following
+// the naming rules of the mongo driver: the bson tag, otherwise the lower
+// cased field name, and inline structs flattened.
+func bsonFields(t reflect.Type) map[string]reflect.Type {
+	if v, ok := bsonFieldsCache.Load(t); ok {
+		return v.(map[string]reflect.Type)
+	}
+
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		opts := strings.Split(f.Tag.Get("bson"), ",")
+		name := opts[0]
+		if name == "-" {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if lo.Contains(opts[1:], "inline") && ft.Kind() == reflect.Struct {
+			for k, v := range bsonFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+
+	bsonFieldsCache.Store(t, fields)
+	return fields
+}
+
+func hasFieldPath(t reflect.Type, path []string) bool {
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		if t.Kind() != reflect.Pointer && len(path) > 0 {
+			if _, err := strconv.Atoi(path[0]); err == nil {
+				path = path[1:]
+			}
+		}
+		t = t.Elem()
+	}
+
+	if len(path) == 0 || t == nil {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Map, reflect.Interface:
+		return true
+	case reflect.Struct:
+		if t == timeType {
+			return false
+		}
+		ft, ok := bsonFields(t)[path[0]]
+		return ok && hasFieldPath(ft, path[1:])
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package hin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"reflect"
+	"time"
+)
+
+// CriteriaValue is the typed wire form of a criteria value. Exactly one field
+// is set, mirroring a protobuf oneof, so that ints, floats and times survive
+// the round trip. Int is encoded as a JSON string like the protobuf JSON
+// mapping of int64.
+type CriteriaValue struct {
+	Null     bool          `json:"null,omitempty"`
+	String   *string       `json:"string,omitempty"`
+	Int      *int64        `json:"int,omitempty,string"`
+	Float    *float64      `json:"float,omitempty"`
+	Bool     *bool         `json:"bool,omitempty"`
+	Time     *time.Time    `json:"time,omitempty"`
+	ObjectID *string       `json:"object_id,omitempty"`
+	List     *CriteriaList `json:"list,omitempty"`
+}
+
+type CriteriaList struct {
+	Values []CriteriaValue `json:"values"`
+}
+
+type criteriaExprJSON struct {
+	Op    string          `json:"op"`
+	Field string          `json:"field,omitempty"`
+	Value *CriteriaValue  `json:"value,omitempty"`
+	Exprs []*CriteriaExpr `json:"exprs,omitempty"`
+}
+
+type criteriaJSON struct {
+	Expr   *CriteriaExpr `json:"expr,omitempty"`
+	Sort   []SortField   `json:"sort,omitempty"`
+	Fields []string      `json:"fields,omitempty"`
+	Limit  int64         `json:"limit,omitempty"`
+	Skip   int64         `json:"skip,omitempty"`
+	Scope  DeletedScope  `json:"scope,omitempty"`
+}
+
+// MarshalJSON encodes the criteria tree with sort, projection, window and
+// scope, the query it was built from is not kept.
+func (c CriteriaBuilder) MarshalJSON() ([]byte, error) {
+	if c.Error != nil {
+		return nil, c.Error
+	}
+	return json.Marshal(criteriaJSON{
+		Expr:   c.Expr,
+		Sort:   c.Orders,
+		Fields: c.Fields,
+		Limit:  c.Size,
+		Skip:   c.Offset,
+		Scope:  c.Scope,
+	})
+}
+
+// UnmarshalJSON decodes and validates criteria received from another service.
+func (c *CriteriaBuilder) UnmarshalJSON(b []byte) error {
+	var w criteriaJSON
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+
+	*c = CriteriaBuilder{
+		Expr:   w.Expr,
+		Orders: w.Sort,
+		Fields: w.Fields,
+		Size:   w.Limit,
+		Offset: w.Skip,
+		Scope:  w.Scope,
+	}
+	return c.Validate()
+}
+
+func (e *CriteriaExpr) MarshalJSON() ([]byte, error) {
+	w := criteriaExprJSON{Op: e.Op, Field: e.Field, Exprs: e.Exprs}
+	if e.Value != nil || e.takesValue() {
+		v, err := NewCriteriaValue(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("criteria: %s of %s: %w", e.Op, e.Field, err)
+		}
+		w.Value = &v
+	}
+	return json.Marshal(w)
+}
+
+func (e *CriteriaExpr) UnmarshalJSON(b []byte) error {
+	var w criteriaExprJSON
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+
+	*e = CriteriaExpr{Op: w.Op, Field: w.Field, Exprs: w.Exprs}
+	if w.Value != nil {
+		v, err := w.Value.Interface()
+		if err != nil {
+			return err
+		}
+		e.Value = v
+	}
+	return nil
+}
+
+func (e *CriteriaExpr) takesValue() bool {
+	switch e.Op {
+	case CriteriaAnd, CriteriaOr, CriteriaNot, CriteriaNull, CriteriaNotNull:
+		return false
+	}
+	return true
+}
+
+// Validate checks the criteria tree for unknown operators and malformed
+// operands, it is applied to criteria decoded from JSON.
+func (c *CriteriaBuilder) Validate() error {
+	if c.Error != nil {
+		return c.Error
+	}
+	if c.Size < 0 || c.Offset < 0 {
+		return errors.New("criteria: limit and skip must not be negative")
+	}
+	if c.Scope < ScopeDefault || c.Scope > ScopeOnlyDeleted {
+		return fmt.Errorf("criteria: unknown scope %d", c.Scope)
+	}
+	for _, s := range c.Orders {
+		if s.Field == "" {
+			return errors.New("criteria: sort field is empty")
+		}
+	}
+	if c.Expr == nil {
+		return nil
+	}
+	return c.Expr.Validate()
+}
+
+func (e *CriteriaExpr) Validate() error {
+	switch e.Op {
+	case CriteriaAnd, CriteriaOr, CriteriaNot:
+		if e.Field != "" || e.Value != nil {
+			return fmt.Errorf("criteria: %s takes no field or value", e.Op)
+		}
+		if len(e.Exprs) == 0 || (e.Op == CriteriaNot && len(e.Exprs) != 1) {
+			return fmt.Errorf("criteria: invalid number of operands for %s", e.Op)
+		}
+		for _, x := range e.Exprs {
+			if x == nil {
+				return fmt.Errorf("criteria: nil operand of %s", e.Op)
+			}
+			if err := x.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, ok := criteriaOps[e.Op]; !ok || e.Op != criteriaOps[e.Op] {
+		return fmt.Errorf("criteria: unknown operator %q", e.Op)
+	}
+	if e.Field == "" {
+		return fmt.Errorf("criteria: %s requires a field", e.Op)
+	}
+	if len(e.Exprs) > 0 {
+		return fmt.Errorf("criteria: %s on %s takes no operands", e.Op, e.Field)
+	}
+
+	rv := reflect.ValueOf(e.Value)
+	isList := rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+
+	switch {
+	case e.Op == CriteriaIn || e.Op == CriteriaNin:
+		if !isList {
+			return fmt.Errorf("criteria: %s on %s requires a list", e.Op, e.Field)
+		}
+	case e.Op == CriteriaBetween:
+		if _, ok := e.Value.([]any); !ok || rv.Len() != 2 {
+			return fmt.Errorf("criteria: between on %s requires two bounds", e.Field)
+		}
+	case e.Op == CriteriaExists:
+		if _, ok := e.Value.(bool); !ok {
+			return fmt.Errorf("criteria: exists on %s requires a bool", e.Field)
+		}
+	case e.Op == CriteriaNull || e.Op == CriteriaNotNull:
+		if e.Value != nil {
+			return fmt.Errorf("criteria: %s on %s takes no value", e.Op, e.Field)
+		}
+	case isPatternOp(e.Op) || e.Op == CriteriaRegex:
+		if _, ok := e.Value.(string); !ok {
+			return fmt.Errorf("criteria: %s on %s requires a string", e.Op, e.Field)
+		}
+	}
+	return nil
+}
+
+// NewCriteriaValue converts a Go value into its wire form.
+func NewCriteriaValue(v any) (CriteriaValue, error) {
+	var cv CriteriaValue
+
+	switch x := v.(type) {
+	case nil:
+		cv.Null = true
+		return cv, nil
+	case string:
+		cv.String = &x
+		return cv, nil
+	case bool:
+		cv.Bool = &x
+		return cv, nil
+	case time.Time:
+		cv.Time = &x
+		return cv, nil
+	case primitive.DateTime:
+		t := x.Time()
+		cv.Time = &t
+		return cv, nil
+	case primitive.ObjectID:
+		s := x.Hex()
+		cv.ObjectID = &s
+		return cv, nil
+	case HID:
+		s := x.String()
+		cv.String = &s
+		return cv, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			cv.Null = true
+			return cv, nil
+		}
+		return NewCriteriaValue(rv.Elem().Interface())
+	case reflect.String:
+		s := rv.String()
+		cv.String = &s
+	case reflect.Bool:
+		b := rv.Bool()
+		cv.Bool = &b
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		cv.Int = &i
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return cv, fmt.Errorf("value %d overflows int64", u)
+		}
+		i := int64(u)
+		cv.Int = &i
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		cv.Float = &f
+	case reflect.Slice, reflect.Array:
+		cv.List = &CriteriaList{Values: make([]CriteriaValue, 0, rv.Len())}
+		for i := 0; i < rv.Len(); i++ {
+			item, err := NewCriteriaValue(rv.Index(i).Interface())
+			if err != nil {
+				return cv, err
+			}
+			cv.List.Values = append(cv.List.Values, item)
+		}
+	default:
+		return cv, fmt.Errorf("unsupported value type %T", v)
+	}
+	return cv, nil
+}
+
+// Interface converts the wire form back into a Go value, ints decode as
+// int64 and lists as []any.
+func (v CriteriaValue) Interface() (any, error) {
+	set := 0
+	var out any
+
+	if v.Null {
+		set++
+	}
+	if v.String != nil {
+		set++
+		out = *v.String
+	}
+	if v.Int != nil {
+		set++
+		out = *v.Int
+	}
+	if v.Float != nil {
+		set++
+		out = *v.Float
+	}
+	if v.Bool != nil {
+		set++
+		out = *v.Bool
+	}
+	if v.Time != nil {
+		set++
+		out = *v.Time
+	}
+	if v.ObjectID != nil {
+		set++
+		id, err := primitive.ObjectIDFromHex(*v.ObjectID)
+		if err != nil {
+			return nil, err
+		}
+		out = id
+	}
+	if v.List != nil {
+		set++
+		list := make([]any, 0, len(v.List.Values))
+		for _, item := range v.List.Values {
+			x, err := item.Interface()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, x)
+		}
+		out = list
+	}
+
+	if set != 1 {
+		return nil, errors.New("criteria: a value must set exactly one field")
+	}
+	return out, nil
+}
+
+func (s DeletedScope) MarshalText() ([]byte, error) {
+	switch s {
+	case ScopeDefault:
+		return []byte("default"), nil
+	case ScopeWithDeleted:
+		return []byte("with_deleted"), nil
+	case ScopeOnlyDeleted:
+		return []byte("only_deleted"), nil
+	}
+	return nil, fmt.Errorf("criteria: unknown scope %d", s)
+}
+
+func (s *DeletedScope) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "", "default":
+		*s = ScopeDefault
+	case "with_deleted":
+		*s = ScopeWithDeleted
+	case "only_deleted":
+		*s = ScopeOnlyDeleted
+	default:
+		return fmt.Errorf("criteria: unknown scope %q", b)
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package hin
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CriteriaSyntaxError is reported through CriteriaBuilder.Error when a string
+// criteria can not be parsed. Pos is the 1-based offset of the offending token.
+type CriteriaSyntaxError struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *CriteriaSyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("criteria: %s at position %d", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("criteria: %s at position %d near %q", e.Msg, e.Pos, e.Token)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPlaceholder
+	tokOperator
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) keyword(kw string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	rs := []rune(s)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", start + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", start + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", start + 1})
+			i++
+		case r == '?':
+			tokens = append(tokens, token{tokPlaceholder, "?", start + 1})
+			i++
+		case r == '\'' || r == '"':
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(rs) {
+				c := rs[i]
+				if c == '\\' && i+1 < len(rs) {
+					sb.WriteRune(rs[i+1])
+					i += 2
+					continue
+				}
+				if c == r {
+					// a doubled quote is an escaped quote, as in SQL
+					if i+1 < len(rs) && rs[i+1] == r {
+						sb.WriteRune(r)
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(c)
+				i++
+			}
+			if !closed {
+				return nil, &CriteriaSyntaxError{start + 1, string(rs[start:]), "unterminated string literal"}
+			}
+			tokens = append(tokens, token{tokString, sb.String(), start + 1})
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			i++
+			if i < len(rs) && (rs[i] == '=' || (r == '<' && rs[i] == '>')) {
+				i++
+			}
+			op := string(rs[start:i])
+			if op == "!" {
+				return nil, &CriteriaSyntaxError{start + 1, op, "unknown operator"}
+			}
+			tokens = append(tokens, token{tokOperator, op, start + 1})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			i++
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' || rs[i] == 'e' || rs[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, string(rs[start:i]), start + 1})
+		case r == '_' || r == '$' || unicode.IsLetter(r):
+			i++
+			for i < len(rs) && (rs[i] == '_' || rs[i] == '.' || rs[i] == '$' || unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(rs[start:i]), start + 1})
+		default:
+			return nil, &CriteriaSyntaxError{start + 1, string(r), "unexpected character"}
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", len(rs) + 1}), nil
+}
+
+// criteriaParser is a recursive descent parser for the string form of Criteria:
+//
+//	expr    = and { OR and }
+//	and     = unary { AND unary }
+//	unary   = NOT unary | primary
+//	primary = "(" expr ")" | condition
+//	cond    = field op value | field [NOT] IN list | field [NOT] match value
+//	        | field BETWEEN value AND value
+//	        | field IS [NOT] NULL | field EXISTS [value]
+//	value   = "?" | string | number | TRUE | FALSE | NULL | ident | "(" value { "," value } ")"
+type criteriaParser struct {
+	tokens []token
+	cur    int
+	args   []any
+	argc   int
+}
+
+func parseCriteria(s string, args ...any) (*CriteriaExpr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &criteriaParser{tokens: tokens, args: args}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected token")
+	}
+
+	if p.argc != len(args) {
+		return nil, fmt.Errorf("criteria: the number of args is not equal to given query, want %d got %d", p.argc, len(args))
+	}
+
+	return expr, nil
+}
+
+func (p *criteriaParser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *criteriaParser) next() token {
+	t := p.tokens[p.cur]
+	if t.kind != tokEOF {
+		p.cur++
+	}
+	return t
+}
+
+func (p *criteriaParser) errorf(t token, format string, args ...any) error {
+	text := t.text
+	if t.kind == tokString {
+		text = strconv.Quote(t.text)
+	}
+	if t.kind == tokEOF {
+		return &CriteriaSyntaxError{t.pos, "", "unexpected end of criteria"}
+	}
+	return &CriteriaSyntaxError{t.pos, text, fmt.Sprintf(format, args...)}
+}
+
+func (p *criteriaParser) parseOr() (*CriteriaExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []*CriteriaExpr{left}
+	for p.peek().keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+
+	if len(exprs) == 1 {
+		return left, nil
+	}
+	return &CriteriaExpr{Op: CriteriaOr, Exprs: exprs}, nil
+}
+
+func (p *criteriaParser) parseAnd() (*CriteriaExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []*CriteriaExpr{left}
+	for p.peek().keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+
+	if len(exprs) == 1 {
+		return left, nil
+	}
+	return &CriteriaExpr{Op: CriteriaAnd, Exprs: exprs}, nil
+}
+
+func (p *criteriaParser) parseUnary() (*CriteriaExpr, error) {
+	if p.peek().keyword("not") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &CriteriaExpr{Op: CriteriaNot, Exprs: []*CriteriaExpr{expr}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *criteriaParser) parsePrimary() (*CriteriaExpr, error) {
+	t := p.next()
+
+	if t.kind == tokLParen {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if rp := p.next(); rp.kind != tokRParen {
+			return nil, p.errorf(rp, "expected )")
+		}
+		return expr, nil
+	}
+
+	if t.kind != tokIdent || isCriteriaKeyword(t.text) {
+		return nil, p.errorf(t, "expected field name")
+	}
+
+	return p.parseCondition(t.text)
+}
+
+func (p *criteriaParser) parseCondition(field string) (*CriteriaExpr, error) {
+	t := p.next()
+
+	var op string
+	switch {
+	case t.kind == tokOperator:
+		var ok bool
+		if op, ok = criteriaOps[t.text]; !ok {
+			return nil, p.errorf(t, "unknown operator")
+		}
+	case t.kind == tokIdent && (isPatternOp(strings.ToLower(t.text)) || t.keyword("regex")):
+		op = strings.ToLower(t.text)
+	case t.keyword("in"):
+		op = CriteriaIn
+	case t.keyword("not"):
+		// NOT IN, or a negated string match such as NOT LIKE
+		n := p.next()
+		if n.keyword("in") {
+			op = CriteriaNin
+			break
+		}
+		if n.kind == tokIdent && (isPatternOp(strings.ToLower(n.text)) || n.keyword("regex")) {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			cond := &CriteriaExpr{Op: strings.ToLower(n.text), Field: field, Value: value}
+			return &CriteriaExpr{Op: CriteriaNot, Exprs: []*CriteriaExpr{cond}}, nil
+		}
+		return nil, p.errorf(n, "expected IN or LIKE")
+	case t.keyword("between"):
+		return p.parseBetween(field)
+	case t.keyword("is"):
+		op = CriteriaNull
+		if p.peek().keyword("not") {
+			p.next()
+			op = CriteriaNotNull
+		}
+		if n := p.next(); !n.keyword("null") {
+			return nil, p.errorf(n, "expected NULL")
+		}
+		return &CriteriaExpr{Op: op, Field: field}, nil
+	case t.keyword("exists"):
+		return p.parseExists(field)
+	default:
+		return nil, p.errorf(t, "expected operator")
+	}
+
+	var value any
+	var err error
+	if op == CriteriaIn || op == CriteriaNin {
+		value, err = p.parseList()
+	} else {
+		value, err = p.parseValue()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &CriteriaExpr{Op: op, Field: field, Value: value}, nil
+}
+
+func (p *criteriaParser) parseBetween(field string) (*CriteriaExpr, error) {
+	lo, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.next(); !t.keyword("and") {
+		return nil, p.errorf(t, "expected AND")
+	}
+	hi, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &CriteriaExpr{Op: CriteriaBetween, Field: field, Value: []any{lo, hi}}, nil
+}
+
+// parseExists parses `field EXISTS` with an optional bool operand, so that
+// `field EXISTS false` and `field EXISTS ?` are accepted as well.
+func (p *criteriaParser) parseExists(field string) (*CriteriaExpr, error) {
+	var value any = true
+	if t := p.peek(); t.kind == tokPlaceholder || t.keyword("true") || t.keyword("false") {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(bool); !ok {
+			return nil, p.errorf(t, "EXISTS requires a bool value")
+		}
+		value = v
+	}
+	return &CriteriaExpr{Op: CriteriaExists, Field: field, Value: value}, nil
+}
+
+// parseList parses the operand of IN, either a single placeholder bound to a
+// slice or a parenthesized list of values.
+func (p *criteriaParser) parseList() (any, error) {
+	if p.peek().kind != tokLParen {
+		return p.parseValue()
+	}
+	p.next()
+
+	values := make([]any, 0)
+	for {
+		placeholder := p.peek().kind == tokPlaceholder
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		// `in (?)` spreads a slice argument into the list
+		if rv := reflect.ValueOf(v); placeholder && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < rv.Len(); i++ {
+				values = append(values, rv.Index(i).Interface())
+			}
+		} else {
+			values = append(values, v)
+		}
+
+		t := p.next()
+		if t.kind == tokRParen {
+			return values, nil
+		}
+		if t.kind != tokComma {
+			return nil, p.errorf(t, "expected , or )")
+		}
+	}
+}
+
+func (p *criteriaParser) parseValue() (any, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokPlaceholder:
+		if p.argc >= len(p.args) {
+			return nil, p.errorf(t, "missing argument for placeholder")
+		}
+		v := p.args[p.argc]
+		p.argc++
+		return v, nil
+	case tokString:
+		return t.text, nil
+	case tokNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf(t, "invalid number")
+	case tokIdent:
+		switch {
+		case t.keyword("true"):
+			return true, nil
+		case t.keyword("false"):
+			return false, nil
+		case t.keyword("null"):
+			return nil, nil
+		case isCriteriaKeyword(t.text):
+			return nil, p.errorf(t, "expected value")
+		}
+		// bare words are accepted as string literals, e.g. `status = active`
+		return t.text, nil
+	}
+
+	return nil, p.errorf(t, "expected value")
+}
+
+func isCriteriaKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not", "like", "in", "between", "is", "exists", "true", "false", "null":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,445 @@
+package hin
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SQLDialect int
+
+const (
+	DialectSQLite SQLDialect = iota
+	DialectMySQL
+	DialectPostgres
+)
+
+func (d SQLDialect) placeholder(n int) string {
+	if d == DialectPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (d SQLDialect) quote(ident string) string {
+	if d == DialectMySQL {
+		return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+// Sql compiles the criteria to a parameterized WHERE clause, without the
+// WHERE keyword, for the columns named like the bson fields with _id as id.
+// It translates Mgo(), so the soft delete scope and the matching semantics
+// are those of Mongo, e.g. ne also selects NULL.
+func (c *CriteriaBuilder) Sql(d SQLDialect) (string, []any, error) {
+	if c.Error != nil {
+		return "", nil, c.Error
+	}
+	w := &sqlWriter{dialect: d}
+	where, err := w.where(c.Mgo())
+	return where, w.args, err
+}
+
+// sqlWriter translates Mongo filter documents to SQL. Values are bound as
+// arguments in the order they appear in the statement.
+type sqlWriter struct {
+	dialect SQLDialect
+	args    []any
+	// column maps a filter field to a column, by default _id to id
+	column func(field string) (string, error)
+	// err is the first value that could not be bound, where returns it
+	err error
+}
+
+func (w *sqlWriter) bind(v any) string {
+	arg, err := sqlValue(v)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.args = append(w.args, arg)
+	return w.dialect.placeholder(len(w.args))
+}
+
+func (w *sqlWriter) col(field string) (string, error) {
+	if w.column != nil {
+		return w.column(field)
+	}
+	if strings.Contains(field, ".") {
+		return "", fmt.Errorf("sql: nested field %s is not supported", field)
+	}
+	return w.dialect.quote(sqlColumn(field)), nil
+}
+
+func sqlColumn(field string) string {
+	if field == "_id" {
+		return "id"
+	}
+	return field
+}
+
+// where returns "" for a filter selecting everything.
+func (w *sqlWriter) where(filter any) (string, error) {
+	switch f := filter.(type) {
+	case CriteriaBuilder:
+		if f.Error != nil {
+			return "", f.Error
+		}
+		filter = f.Mgo()
+	case *CriteriaBuilder:
+		if f.Error != nil {
+			return "", f.Error
+		}
+		filter = f.Mgo()
+	}
+
+	doc, err := toBsonM(filter)
+	if err != nil {
+		return "", err
+	}
+	where, err := w.doc(doc)
+	if err == nil {
+		err = w.err
+	}
+	return where, err
+}
+
+func (w *sqlWriter) doc(doc bson.M) (string, error) {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var s string
+		var err error
+		switch k {
+		case "$and", "$or", "$nor":
+			s, err = w.logical(k, doc[k])
+		default:
+			if strings.HasPrefix(k, "$") {
+				return "", fmt.Errorf("sql: unsupported operator %s", k)
+			}
+			s, err = w.field(k, doc[k])
+		}
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " AND "), nil
+}
+
+func (w *sqlWriter) logical(op string, cond any) (string, error) {
+	clauses, ok := cond.(bson.A)
+	if !ok {
+		return "", fmt.Errorf("sql: %s requires an array", op)
+	}
+
+	parts := make([]string, 0, len(clauses))
+	for _, c := range clauses {
+		sub, ok := c.(bson.M)
+		if !ok {
+			return "", fmt.Errorf("sql: %s requires an array of documents", op)
+		}
+		s, err := w.doc(sub)
+		if err != nil {
+			return "", err
+		}
+		if s == "" {
+			s = "1=1"
+		}
+		parts = append(parts, "("+s+")")
+	}
+
+	switch {
+	case len(parts) == 0 && op == "$or":
+		return "1=0", nil
+	case len(parts) == 0:
+		return "1=1", nil
+	case op == "$and":
+		return strings.Join(parts, " AND "), nil
+	case op == "$or":
+		return "(" + strings.Join(parts, " OR ") + ")", nil
+	}
+	// a NULL operand counts as not matching, as a missing field does in Mongo
+	return "NOT COALESCE(" + strings.Join(parts, " OR ") + ", 1=0)", nil
+}
+
+func (w *sqlWriter) field(field string, cond any) (string, error) {
+	col, err := w.col(field)
+	if err != nil {
+		return "", err
+	}
+
+	ops, ok := cond.(bson.M)
+	if !ok || !isMgoOps(ops) {
+		return w.eq(col, cond), nil
+	}
+
+	keys := make([]string, 0, len(ops))
+	for k := range ops {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, op := range keys {
+		s, err := w.op(col, op, ops[op], ops)
+		if err != nil {
+			return "", err
+		}
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " AND "), nil
+}
+
+func (w *sqlWriter) eq(col string, v any) string {
+	if v == nil {
+		return col + " IS NULL"
+	}
+	return col + " = " + w.bind(v)
+}
+
+func (w *sqlWriter) op(col string, op string, arg any, ops bson.M) (string, error) {
+	switch op {
+	case "$eq":
+		return w.eq(col, arg), nil
+	case "$ne":
+		if arg == nil {
+			return col + " IS NOT NULL", nil
+		}
+		return "(" + col + " <> " + w.bind(arg) + " OR " + col + " IS NULL)", nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if arg == nil {
+			if op == "$gte" || op == "$lte" {
+				return col + " IS NULL", nil
+			}
+			return "1=0", nil
+		}
+		sym := map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+		return col + " " + sym + " " + w.bind(arg), nil
+	case "$in", "$nin":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return "", fmt.Errorf("sql: %s requires an array", op)
+		}
+		var phs []string
+		null := false
+		for _, v := range list {
+			if v == nil {
+				null = true
+				continue
+			}
+			phs = append(phs, w.bind(v))
+		}
+		if op == "$in" {
+			var or []string
+			if len(phs) > 0 {
+				or = append(or, col+" IN ("+strings.Join(phs, ", ")+")")
+			}
+			if null {
+				or = append(or, col+" IS NULL")
+			}
+			if len(or) == 0 {
+				return "1=0", nil
+			}
+			return "(" + strings.Join(or, " OR ") + ")", nil
+		}
+		if len(phs) == 0 {
+			if null {
+				return col + " IS NOT NULL", nil
+			}
+			return "1=1", nil
+		}
+		if null {
+			return col + " NOT IN (" + strings.Join(phs, ", ") + ")", nil
+		}
+		return "(" + col + " NOT IN (" + strings.Join(phs, ", ") + ") OR " + col + " IS NULL)", nil
+	case "$exists":
+		if truthy(arg) {
+			return col + " IS NOT NULL", nil
+		}
+		return col + " IS NULL", nil
+	case "$regex":
+		options, _ := ops["$options"].(string)
+		return w.regex(col, arg, options)
+	case "$options":
+		return "", nil
+	case "$not":
+		var s string
+		var err error
+		if sub, ok := arg.(bson.M); ok {
+			var parts []string
+			keys := make([]string, 0, len(sub))
+			for k := range sub {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				p, err := w.op(col, k, sub[k], sub)
+				if err != nil {
+					return "", err
+				}
+				if p != "" {
+					parts = append(parts, p)
+				}
+			}
+			s = strings.Join(parts, " AND ")
+		} else if s, err = w.regex(col, arg, ""); err != nil {
+			return "", err
+		}
+		return "NOT COALESCE(" + s + ", 1=0)", nil
+	}
+	return "", fmt.Errorf("sql: unsupported operator %s", op)
+}
+
+// regex translates the escaped patterns of the string match operators back
+// to LIKE, or GLOB for case sensitive matching on SQLite. Other regular
+// expressions need REGEXP support of the database, which SQLite lacks.
+func (w *sqlWriter) regex(col string, pattern any, options string) (string, error) {
+	var expr string
+	switch p := pattern.(type) {
+	case string:
+		expr = p
+	case primitive.Regex:
+		expr, options = p.Pattern, p.Options+options
+	default:
+		return "", fmt.Errorf("sql: invalid $regex %v", pattern)
+	}
+	fold := strings.Contains(options, "i")
+
+	if lit, prefix, suffix, ok := regexLiteral(expr); ok {
+		if fold {
+			return "LOWER(" + col + ") LIKE " + w.bind(likePattern(strings.ToLower(lit), prefix, suffix)) + " ESCAPE '!'", nil
+		}
+		if w.dialect == DialectSQLite {
+			return col + " GLOB " + w.bind(globPattern(lit, prefix, suffix)), nil
+		}
+		if w.dialect == DialectMySQL {
+			return col + " LIKE BINARY " + w.bind(likePattern(lit, prefix, suffix)) + " ESCAPE '!'", nil
+		}
+		return col + " LIKE " + w.bind(likePattern(lit, prefix, suffix)) + " ESCAPE '!'", nil
+	}
+
+	switch w.dialect {
+	case DialectPostgres:
+		if fold {
+			return col + " ~* " + w.bind(expr), nil
+		}
+		return col + " ~ " + w.bind(expr), nil
+	case DialectMySQL:
+		if fold {
+			return "REGEXP_LIKE(" + col + ", " + w.bind(expr) + ", 'i')", nil
+		}
+		return "REGEXP_LIKE(" + col + ", " + w.bind(expr) + ", 'c')", nil
+	}
+	return "", fmt.Errorf("sql: regular expression %q is not supported by the dialect", expr)
+}
+
+// regexLiteral recognizes a regexp.QuoteMeta escaped string, optionally
+// anchored, and returns the literal; prefix and suffix tell the anchors.
+func regexLiteral(expr string) (lit string, prefix bool, suffix bool, ok bool) {
+	const meta = `\.+*?()|[]{}^$`
+
+	if strings.HasPrefix(expr, "^") {
+		prefix, expr = true, expr[1:]
+	}
+	if strings.HasSuffix(expr, "$") && !strings.HasSuffix(expr, `\$`) {
+		suffix, expr = true, expr[:len(expr)-1]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr) && strings.IndexByte(meta, expr[i+1]) >= 0:
+			i++
+			b.WriteByte(expr[i])
+		case strings.IndexByte(meta, c) >= 0:
+			return "", false, false, false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), prefix, suffix, true
+}
+
+func likePattern(lit string, prefix, suffix bool) string {
+	lit = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(lit)
+	if !prefix {
+		lit = "%" + lit
+	}
+	if !suffix {
+		lit += "%"
+	}
+	return lit
+}
+
+func globPattern(lit string, prefix, suffix bool) string {
+	lit = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]").Replace(lit)
+	if !prefix {
+		lit = "*" + lit
+	}
+	if !suffix {
+		lit += "*"
+	}
+	return lit
+}
+
+// sqlValue converts a normalized bson value to a database/sql argument.
+// Times are stored in UTC with the millisecond precision of Mongo, nested
+// documents and arrays as extended JSON.
+func sqlValue(v any) (any, error) {
+	switch x := v.(type) {
+	case primitive.DateTime:
+		return x.Time().UTC(), nil
+	case time.Time:
+		return x.UTC().Truncate(time.Millisecond), nil
+	case primitive.ObjectID:
+		return x.Hex(), nil
+	case HID:
+		return x.String(), nil
+	case int32:
+		return int64(x), nil
+	case int:
+		return int64(x), nil
+	case primitive.Binary:
+		return x.Data, nil
+	case bson.M, bson.D, bson.A:
+		return sqlJSON(x)
+	}
+	return v, nil
+}
+
+// sqlJSON encodes a nested value as relaxed extended JSON, wrapped in a
+// document since arrays cannot be encoded on their own.
+func sqlJSON(v any) (string, error) {
+	b, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	return s[len(`{"v":`) : len(s)-1], nil
+}
+
+func sqlUnJSON(s string) (any, error) {
+	var doc bson.M
+	if err := bson.UnmarshalExtJSON([]byte(`{"v":`+s+`}`), false, &doc); err != nil {
+		return nil, err
+	}
+	v, ok := doc["v"]
+	if !ok {
+		return nil, errors.New("sql: invalid nested value")
+	}
+	return v, nil
+}
@@ -1,6 +1,15 @@
 package hin
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestCriteria(t *testing.T) {
 	builder := Criteria("username = ? AND password = ? AND id != 1 OR id = m OR item != ? AND tt = 1", "hancens", "123456", "asb")
@@ -9,3 +18,453 @@ func TestCriteria(t *testing.T) {
 	bs := builder.Mgo()
 	t.Log(bs)
 }
+
+func TestCriteriaMgo(t *testing.T) {
+	cases := []struct {
+		query string
+		args  []any
+		want  bson.M
+	}{
+		{
+			"username = ? AND password = ?",
+			[]any{"hancens", "123456"},
+			bson.M{"username": "hancens", "password": "123456", "deleted_at": nil},
+		},
+		{
+			"a = 1 AND b = 2 OR c = 3",
+			nil,
+			bson.M{"$or": []bson.M{{"a": int64(1), "b": int64(2)}, {"c": int64(3)}}, "deleted_at": nil},
+		},
+		{
+			"a = 1 AND (b = 2 OR c = 3)",
+			nil,
+			bson.M{"a": int64(1), "$or": []bson.M{{"b": int64(2)}, {"c": int64(3)}}, "deleted_at": nil},
+		},
+		{
+			"(a = 1 OR b = 2) AND (c = 3 OR d = 4)",
+			nil,
+			bson.M{"$and": []bson.M{
+				{"$or": []bson.M{{"a": int64(1)}, {"b": int64(2)}}},
+				{"$or": []bson.M{{"c": int64(3)}, {"d": int64(4)}}},
+			}, "deleted_at": nil},
+		},
+		{
+			"NOT name = 'John Doe' and id != ?",
+			[]any{"x"},
+			bson.M{"$nor": []bson.M{{"name": "John Doe"}}, "_id": bson.M{"$ne": "x"}, "deleted_at": nil},
+		},
+		{
+			"status in ('a', 'b') and enabled = true and score != 1.5 and title like ?",
+			[]any{"what ? is"},
+			bson.M{
+				"status":     bson.M{"$in": []any{"a", "b"}},
+				"enabled":    true,
+				"score":      bson.M{"$ne": 1.5},
+				"title":      bson.M{"$regex": `what \? is`},
+				"deleted_at": nil,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		builder := Criteria(c.query, c.args...)
+		if builder.Error != nil {
+			t.Fatalf("%s: %v", c.query, builder.Error)
+		}
+		if got := builder.Mgo(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got %v\nwant %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestCriteriaSyntaxError(t *testing.T) {
+	cases := []struct {
+		query string
+		args  []any
+		pos   int
+	}{
+		{"a = 1 AND", nil, 10},
+		{"a = 1 AND (b = 2", nil, 17},
+		{"a == 1", nil, 3},
+		{"a = 'open", nil, 5},
+		{"a = ? OR b = ?", []any{1}, 14},
+	}
+
+	for _, c := range cases {
+		builder := Criteria(c.query, c.args...)
+		var se *CriteriaSyntaxError
+		if !errors.As(builder.Error, &se) {
+			t.Fatalf("%s: expected syntax error, got %v", c.query, builder.Error)
+		}
+		if se.Pos != c.pos {
+			t.Errorf("%s: expected position %d, got %d (%v)", c.query, c.pos, se.Pos, se)
+		}
+		if builder.Mgo() != nil {
+			t.Errorf("%s: expected nil filter on error", c.query)
+		}
+	}
+
+	if b := Criteria("a = ?", 1, 2); b.Error == nil {
+		t.Error("expected error on extra args")
+	}
+}
+
+func TestCriteriaRangeOperators(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	builder := Criteria("age >= ? AND age < 60 AND score BETWEEN 1 AND 5 AND role NOT IN (?) AND phone IS NULL AND email IS NOT NULL AND avatar EXISTS", 18, []string{"root"})
+	want := bson.M{
+		"age":        bson.M{"$gte": 18, "$lt": int64(60)},
+		"score":      bson.M{"$gte": int64(1), "$lte": int64(5)},
+		"role":       bson.M{"$nin": []any{"root"}},
+		"phone":      nil,
+		"email":      bson.M{"$ne": nil},
+		"avatar":     bson.M{"$exists": true},
+		"deleted_at": nil,
+	}
+	if got := builder.Mgo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	type query struct {
+		Name      string      `criteria:"like"`
+		From      time.Time   `criteria:"gte,created_at"`
+		To        time.Time   `criteria:"lt,created_at"`
+		Status    []int       `criteria:"nin"`
+		Score     [2]int      `criteria:"between"`
+		Age       [2]*int     `criteria:"between"`
+		HasAvatar bool        `criteria:"exists,avatar"`
+		Deleted   bool        `criteria:"notnull,deleted_at"`
+		Remark    string      `criteria:"nil"`
+		Level     int         `criteria:"-"`
+		ID        string      `criteria:"ne"`
+		Ignored   interface{} `criteria:"eq"`
+	}
+
+	maxAge := 30
+	builder = Criteria(query{
+		From:      from,
+		To:        to,
+		Status:    []int{1, 2},
+		Score:     [2]int{0, 9},
+		Age:       [2]*int{nil, &maxAge},
+		HasAvatar: true,
+		Deleted:   true,
+		Level:     3,
+		ID:        "x",
+	})
+	want = bson.M{
+		"created_at": bson.M{"$gte": from, "$lt": to},
+		"status":     bson.M{"$nin": []int{1, 2}},
+		"score":      bson.M{"$gte": 0, "$lte": 9},
+		"age":        bson.M{"$lte": 30},
+		"avatar":     bson.M{"$exists": true},
+		"deleted_at": bson.M{"$ne": nil},
+		"remark":     nil,
+		"_id":        bson.M{"$ne": "x"},
+	}
+	if builder.Error != nil {
+		t.Fatal(builder.Error)
+	}
+	if got := builder.Mgo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestCriteriaHandBuiltExpr(t *testing.T) {
+	builder := CriteriaBuilder{Expr: &CriteriaExpr{Op: CriteriaBetween, Field: "score", Value: 5}}
+	if got := builder.Mgo(); got != nil || builder.Error == nil {
+		t.Errorf("Mgo() = %v, %v", got, builder.Error)
+	}
+}
+
+func TestCriteriaFindOptions(t *testing.T) {
+	builder := Criteria("status = ?", 1).OrderBy("-priority", "+name").Select("id", "name").Limit(5).Skip(10)
+
+	o := newFindOptions(builder.FindOptions())
+	if want := []SortField{{Field: "priority", Desc: true}, {Field: "name"}}; !reflect.DeepEqual(o.Sort, want) {
+		t.Errorf("sort got %v want %v", o.Sort, want)
+	}
+	if want := (bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: 1}}); !reflect.DeepEqual(o.mgoProjection(), want) {
+		t.Errorf("projection got %v want %v", o.mgoProjection(), want)
+	}
+	if o.Limit != 5 || o.Skip != 10 {
+		t.Errorf("window got limit %d skip %d", o.Limit, o.Skip)
+	}
+	if fo := o.mgoFind(); *fo.Limit != 5 || *fo.Skip != 10 || fo.BatchSize != nil {
+		t.Errorf("find options got limit %d skip %d", *fo.Limit, *fo.Skip)
+	}
+
+	if o := newFindOptions(Criteria("").FindOptions()); !reflect.DeepEqual(o.mgoSort(), bson.D{{Key: "created_at", Value: -1}}) {
+		t.Errorf("default sort got %v", o.mgoSort())
+	}
+}
+
+func TestCriteriaWhere(t *testing.T) {
+	cases := []struct {
+		fluent CriteriaBuilder
+		str    CriteriaBuilder
+	}{
+		{
+			Where("status").Eq(int64(1)).And(Where("age").Gte(int64(18))).Or(Where("role").In("admin", "root")),
+			Criteria("status = 1 AND age >= 18 OR role IN ('admin', 'root')"),
+		},
+		{
+			Where("a").Eq(int64(1)).And(Where("b").Eq(int64(2)).Or(Where("c").Eq(int64(3)))),
+			Criteria("a = 1 AND (b = 2 OR c = 3)"),
+		},
+		{
+			Where("name").Like("foo").Not().And(Where("id").Ne("x"), Where("score").Between(int64(1), int64(5))),
+			Criteria("NOT name LIKE 'foo' AND id != ? AND score BETWEEN 1 AND 5", "x"),
+		},
+		{
+			Criteria("a = ?", 1).And(Where("phone").IsNull(), Where("tags").Nin([]string{"x", "y"})),
+			Criteria("a = ? AND phone IS NULL AND tags NOT IN (?)", 1, []string{"x", "y"}),
+		},
+	}
+
+	for _, c := range cases {
+		if !reflect.DeepEqual(c.fluent.Expr, c.str.Expr) {
+			t.Errorf("expr mismatch: %+v != %+v", c.fluent.Expr, c.str.Expr)
+		}
+		if got, want := c.fluent.Mgo(), c.str.Mgo(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	}
+
+	if b := Where("a").Eq(1).And(Criteria("b = ?")); b.Error == nil {
+		t.Error("expected error of operand to propagate")
+	}
+}
+
+func TestCriteriaDeletedScope(t *testing.T) {
+	cases := []struct {
+		builder CriteriaBuilder
+		want    bson.M
+	}{
+		{Criteria("a = 1"), bson.M{"a": int64(1), "deleted_at": nil}},
+		{Criteria("a = 1").WithDeleted(), bson.M{"a": int64(1)}},
+		{Criteria("a = 1").OnlyDeleted(), bson.M{"a": int64(1), "deleted_at": bson.M{"$ne": nil}}},
+		{Where("deleted_at").Gt(int64(1)).OnlyDeleted(), bson.M{"$and": []bson.M{
+			{"deleted_at": bson.M{"$gt": int64(1)}},
+			{"deleted_at": bson.M{"$ne": nil}},
+		}}},
+	}
+
+	for _, c := range cases {
+		if got := c.builder.Mgo(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("got %v\nwant %v", got, c.want)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	type userFilter struct {
+		Age       int       `filter:"age"`
+		Name      string    `filter:"name"`
+		Status    int       `filter:"status"`
+		CreatedAt time.Time `filter:"created_at,sort"`
+		Password  string
+	}
+
+	builder, err := ParseFilter(`age>=18,name~foo\,bar,status=1|2,created_at<1700000000000`, "-created_at", userFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"age":        bson.M{"$gte": 18},
+		"name":       bson.M{"$regex": "foo,bar"},
+		"status":     bson.M{"$in": []any{1, 2}},
+		"created_at": bson.M{"$lt": time.UnixMilli(1700000000000)},
+		"deleted_at": nil,
+	}
+	if got := builder.Mgo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if want := []SortField{{Field: "created_at", Desc: true}}; !reflect.DeepEqual(builder.Orders, want) {
+		t.Errorf("sort got %v", builder.Orders)
+	}
+
+	for _, c := range []struct{ filter, sort string }{
+		{"password=x", ""},
+		{"age>=x", ""},
+		{"age", ""},
+		{"", "-age"},
+	} {
+		if _, err := ParseFilter(c.filter, c.sort, &userFilter{}); err == nil {
+			t.Errorf("expected error for filter %q sort %q", c.filter, c.sort)
+		}
+	}
+}
+
+func TestCriteriaMatch(t *testing.T) {
+	type user struct {
+		ID        string     `bson:"_id"`
+		Name      string     `bson:"name"`
+		Age       int        `bson:"age"`
+		Tags      []string   `bson:"tags"`
+		CreatedAt time.Time  `bson:"created_at"`
+		DeletedAt *time.Time `bson:"deleted_at,omitempty"`
+	}
+
+	now := time.Now()
+	u := user{ID: "u1", Name: "John Doe", Age: 30, Tags: []string{"a", "b"}, CreatedAt: now}
+
+	cases := []struct {
+		builder CriteriaBuilder
+		want    bool
+	}{
+		{Criteria("id = ?", "u1"), true},
+		{Criteria("name prefix ? AND age >= 18", "John"), true},
+		{Criteria("name like ?", "^John"), false},
+		{Criteria("name ilike ? AND name NOT suffix 'do'", "JOHN d"), true},
+		{Criteria("age BETWEEN 31 AND 40 OR tags IN ('x', 'b')"), true},
+		{Criteria("age > 30"), false},
+		{Criteria("tags = 'a' AND NOT name = 'Jane'"), true},
+		{Criteria("age != 30"), false},
+		{Criteria("phone IS NULL AND name IS NOT NULL AND age EXISTS"), true},
+		{Criteria("created_at < ?", now.Add(time.Minute)), true},
+		{Criteria("age = '30'"), false},
+		{Criteria("age = 30").OnlyDeleted(), false},
+		{Criteria("age = ?"), false},
+	}
+
+	for _, c := range cases {
+		if got := c.builder.Match(u); got != c.want {
+			t.Errorf("%s: got %v want %v", c.builder.SQL, got, c.want)
+		}
+	}
+
+	u.DeletedAt = &now
+	if Criteria("age = 30").Match(u) || !Criteria("age = 30").OnlyDeleted().Match(&u) {
+		t.Error("soft deleted document matched the wrong scope")
+	}
+
+	if !Where("name").Eq("x").Match(map[string]any{"name": "x"}) {
+		t.Error("map did not match")
+	}
+}
+
+func TestCriteriaPattern(t *testing.T) {
+	cases := []struct {
+		builder CriteriaBuilder
+		want    bson.M
+	}{
+		{Criteria("name like ?", "(a+)+"), bson.M{"name": bson.M{"$regex": `\(a\+\)\+`}}},
+		{Criteria("name contains '.*'"), bson.M{"name": bson.M{"$regex": `\.\*`}}},
+		{Criteria("name prefix ?", "a.b"), bson.M{"name": bson.M{"$regex": `^a\.b`}}},
+		{Criteria("name isuffix ?", "$x"), bson.M{"name": bson.M{"$regex": `\$x$`, "$options": "i"}}},
+		{Criteria("name regex ?", "^a.*"), bson.M{"name": bson.M{"$regex": "^a.*"}}},
+		{Criteria("NOT name like 'a' AND prefix = 1"), bson.M{"$nor": []bson.M{{"name": bson.M{"$regex": "a"}}}, "prefix": int64(1)}},
+		{Criteria(struct {
+			Name string `criteria:"iprefix"`
+		}{"Jo*"}), bson.M{"name": bson.M{"$regex": `^Jo\*`, "$options": "i"}}},
+	}
+
+	for _, c := range cases {
+		builder := c.builder.WithDeleted()
+		if got := builder.Mgo(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("got %v\nwant %v", got, c.want)
+		}
+	}
+}
+
+func TestCriteriaJSON(t *testing.T) {
+	at := time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC)
+	builder := Criteria("status IN (?) AND created_at >= ? AND (name prefix ? OR score BETWEEN 1.5 AND 9) AND phone IS NULL AND avatar EXISTS AND remark = null",
+		[]int{1, 2}, at, "jo").OrderBy("-created_at").Select("id", "name").Limit(5).OnlyDeleted()
+
+	b, err := json.Marshal(builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(b))
+
+	var decoded CriteriaBuilder
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.Mgo(), builder.Mgo(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if !reflect.DeepEqual(decoded.Orders, builder.Orders) || !reflect.DeepEqual(decoded.Fields, builder.Fields) ||
+		decoded.Size != 5 || decoded.Scope != ScopeOnlyDeleted {
+		t.Errorf("options were not decoded: %+v", decoded)
+	}
+
+	for _, s := range []string{
+		`{"expr":{"op":"where","field":"a","value":{"int":"1"}}}`,
+		`{"expr":{"op":"eq","value":{"int":"1"}}}`,
+		`{"expr":{"op":"in","field":"a","value":{"int":"1"}}}`,
+		`{"expr":{"op":"not","exprs":[]}}`,
+		`{"expr":{"op":"eq","field":"a","value":{"int":"1","string":"x"}}}`,
+		`{"expr":{"op":"like","field":"a","value":{"int":"1"}}}`,
+		`{"scope":"all"}`,
+	} {
+		if err := json.Unmarshal([]byte(s), &decoded); err == nil {
+			t.Errorf("expected error for %s", s)
+		}
+	}
+}
+
+func TestCriteriaStrict(t *testing.T) {
+	for env, strict := range map[string]bool{"": false, "prod": false, "dev": true, "test": true} {
+		viper.Set("env", env)
+		if CriteriaStrict() != strict {
+			t.Errorf("CriteriaStrict() with env %q = %v", env, !strict)
+		}
+	}
+	viper.Set("env", nil)
+
+	viper.Set("criteria.strict", true)
+	defer viper.Set("criteria.strict", nil)
+
+	type query struct {
+		Name  string         `criteria:"lkie"`
+		Attrs map[string]int `criteria:"eq"`
+	}
+	if b := Criteria(query{}); b.Error == nil {
+		t.Error("expected error on unknown tag operator")
+	}
+
+	type query2 struct {
+		Attrs map[string]int
+	}
+	if b := Criteria(query2{Attrs: map[string]int{"a": 1}}); b.Error == nil {
+		t.Error("expected error on unsupported field type")
+	}
+
+	viper.Set("criteria.strict", false)
+	if b := Criteria(query{Name: "x"}); b.Error != nil {
+		t.Errorf("unexpected error in lenient mode: %v", b.Error)
+	}
+
+	type profile struct {
+		City string `bson:"city"`
+	}
+	type model struct {
+		BaseModel `bson:",inline"`
+		Name      string    `bson:"name"`
+		Profile   profile   `bson:"profile"`
+		Items     []profile `bson:"items"`
+		Extra     bson.M    `bson:"extra"`
+		Age       int
+	}
+
+	for _, c := range []struct {
+		builder CriteriaBuilder
+		ok      bool
+	}{
+		{Criteria("id = 1 AND name = 'x' AND profile.city = 'y' AND items.0.city = 'z' AND extra.any = 1 AND age > 1"), true},
+		{Criteria("created_at > 1").OrderBy("-updated_at").Select("name"), true},
+		{Criteria("nmae = 'x'"), false},
+		{Criteria("profile.zip = 'x'"), false},
+		{Criteria("name = 'x'").OrderBy("password"), false},
+		{Criteria("name = 'x'").Select("password"), false},
+	} {
+		if err := c.builder.CheckFields(model{}); (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result %v", c.builder.SQL, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package hin
+
+import "reflect"
+
+// CriteriaField starts a condition of the fluent criteria builder:
+//
+//	Where("status").Eq(1).And(Where("age").Gte(18)).Or(Where("role").In("admin", "root"))
+type CriteriaField struct {
+	field string
+}
+
+func Where(field string) CriteriaField {
+	return CriteriaField{field}
+}
+
+func (f CriteriaField) cond(op string, value any) CriteriaBuilder {
+	return CriteriaBuilder{Expr: &CriteriaExpr{Op: op, Field: f.field, Value: value}}
+}
+
+func (f CriteriaField) Eq(v any) CriteriaBuilder {
+	return f.cond(CriteriaEq, v)
+}
+
+func (f CriteriaField) Ne(v any) CriteriaBuilder {
+	return f.cond(CriteriaNe, v)
+}
+
+func (f CriteriaField) Gt(v any) CriteriaBuilder {
+	return f.cond(CriteriaGt, v)
+}
+
+func (f CriteriaField) Gte(v any) CriteriaBuilder {
+	return f.cond(CriteriaGte, v)
+}
+
+func (f CriteriaField) Lt(v any) CriteriaBuilder {
+	return f.cond(CriteriaLt, v)
+}
+
+func (f CriteriaField) Lte(v any) CriteriaBuilder {
+	return f.cond(CriteriaLte, v)
+}
+
+func (f CriteriaField) Between(lo, hi any) CriteriaBuilder {
+	return f.cond(CriteriaBetween, []any{lo, hi})
+}
+
+// Like matches v literally anywhere in the field.
+func (f CriteriaField) Like(v string) CriteriaBuilder {
+	return f.cond(CriteriaLike, v)
+}
+
+func (f CriteriaField) ILike(v string) CriteriaBuilder {
+	return f.cond(CriteriaILike, v)
+}
+
+func (f CriteriaField) Contains(v string) CriteriaBuilder {
+	return f.cond(CriteriaContains, v)
+}
+
+func (f CriteriaField) IContains(v string) CriteriaBuilder {
+	return f.cond(CriteriaIContains, v)
+}
+
+func (f CriteriaField) Prefix(v string) CriteriaBuilder {
+	return f.cond(CriteriaPrefix, v)
+}
+
+func (f CriteriaField) IPrefix(v string) CriteriaBuilder {
+	return f.cond(CriteriaIPrefix, v)
+}
+
+func (f CriteriaField) Suffix(v string) CriteriaBuilder {
+	return f.cond(CriteriaSuffix, v)
+}
+
+func (f CriteriaField) ISuffix(v string) CriteriaBuilder {
+	return f.cond(CriteriaISuffix, v)
+}
+
+// Regex matches the field with a raw regular expression, never pass user
+// input here unescaped.
+func (f CriteriaField) Regex(pattern string) CriteriaBuilder {
+	return f.cond(CriteriaRegex, pattern)
+}
+
+// In accepts either the values themselves or a single slice of values.
+func (f CriteriaField) In(values ...any) CriteriaBuilder {
+	return f.cond(CriteriaIn, spreadValues(values))
+}
+
+func (f CriteriaField) Nin(values ...any) CriteriaBuilder {
+	return f.cond(CriteriaNin, spreadValues(values))
+}
+
+func (f CriteriaField) IsNull() CriteriaBuilder {
+	return f.cond(CriteriaNull, nil)
+}
+
+func (f CriteriaField) NotNull() CriteriaBuilder {
+	return f.cond(CriteriaNotNull, nil)
+}
+
+func (f CriteriaField) Exists() CriteriaBuilder {
+	return f.cond(CriteriaExists, true)
+}
+
+func (f CriteriaField) NotExists() CriteriaBuilder {
+	return f.cond(CriteriaExists, false)
+}
+
+// And joins the criteria with AND. The sort, projection and window of the
+// receiver are kept, those of the operands are ignored.
+func (c CriteriaBuilder) And(others ...CriteriaBuilder) CriteriaBuilder {
+	return c.join(CriteriaAnd, others)
+}
+
+// Or joins the criteria with OR. Chained calls apply left to right, so
+// a.Or(b).And(c) reads as (a OR b) AND c.
+func (c CriteriaBuilder) Or(others ...CriteriaBuilder) CriteriaBuilder {
+	return c.join(CriteriaOr, others)
+}
+
+func (c CriteriaBuilder) Not() CriteriaBuilder {
+	if c.Error != nil || c.Expr == nil {
+		return c
+	}
+	c.Query, c.SQL, c.Vars = nil, "", nil
+	c.Expr = &CriteriaExpr{Op: CriteriaNot, Exprs: []*CriteriaExpr{c.Expr}}
+	return c
+}
+
+func (c CriteriaBuilder) join(op string, others []CriteriaBuilder) CriteriaBuilder {
+	if c.Error != nil {
+		return c
+	}
+
+	var exprs []*CriteriaExpr
+	add := func(x *CriteriaExpr) {
+		if x == nil {
+			return
+		}
+		if x.Op == op {
+			exprs = append(exprs, x.Exprs...)
+		} else {
+			exprs = append(exprs, x)
+		}
+	}
+
+	add(c.Expr)
+	for _, o := range others {
+		if o.Error != nil {
+			c.Error = o.Error
+			return c
+		}
+		add(o.Expr)
+	}
+
+	c.Query, c.SQL, c.Vars = nil, "", nil
+	switch len(exprs) {
+	case 0:
+		c.Expr = nil
+	case 1:
+		c.Expr = exprs[0]
+	default:
+		c.Expr = &CriteriaExpr{Op: op, Exprs: exprs}
+	}
+	return c
+}
+
+func spreadValues(values []any) []any {
+	if len(values) == 1 {
+		if rv := reflect.ValueOf(values[0]); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			vs := make([]any, 0, rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				vs = append(vs, rv.Index(i).Interface())
+			}
+			return vs
+		}
+	}
+	if values == nil {
+		return []any{}
+	}
+	return values
+}
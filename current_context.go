@@ -11,6 +11,14 @@ type CurrentContext struct {
 	Logger *Logger
 }
 
+// Tenant returns the tenant of the claims, empty without claims.
+func (c *CurrentContext) Tenant() string {
+	if c == nil || c.Claims == nil {
+		return ""
+	}
+	return c.Claims.TenantID
+}
+
 var (
 	ctxMap = map[string]*CurrentContext{}
 	ctxMux = &sync.Mutex{}
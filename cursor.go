@@ -0,0 +1,212 @@
+package hin
+
+import (
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// CursorPage describes the neighbours of a page read with a CursorQuery.
+// Total is -1 when the count was skipped.
+type CursorPage struct {
+	Next    string
+	Prev    string
+	HasNext bool
+	HasPrev bool
+	Total   int64
+}
+
+type cursorToken struct {
+	Sort   []string `bson:"s"`
+	Values bson.A   `bson:"v"`
+}
+
+// cursorPlan turns a CursorQuery into a keyset query. The sort of the find
+// options is completed with _id so that every row has a distinct key, reading
+// before a cursor runs the query in reverse order.
+type cursorPlan struct {
+	filter  any
+	opts    *FindOptions
+	keys    []SortField
+	limit   int64
+	after   bool
+	before  bool
+	reverse bool
+}
+
+func newCursorPlan(filter any, q CursorQuery, opts []FindOption) (*cursorPlan, error) {
+	if q.After != "" && q.Before != "" {
+		return nil, NewError(errors.New("cursor: after and before are exclusive"), ErrParameterError)
+	}
+
+	o := newFindOptions(opts)
+	p := &cursorPlan{filter: filter, limit: q.Count, after: q.After != "", before: q.Before != ""}
+	if p.limit <= 0 {
+		p.limit = 20
+	}
+
+	hasID := false
+	for _, s := range o.Sort {
+		s.Field = mgoField(s.Field)
+		hasID = hasID || s.Field == "_id"
+		p.keys = append(p.keys, s)
+	}
+	if !hasID {
+		p.keys = append(p.keys, SortField{Field: "_id", Desc: p.keys[len(p.keys)-1].Desc})
+	}
+
+	// the keys are read from the rows, keep them in the projection
+	if len(o.Projection) > 0 {
+		for _, k := range p.keys {
+			o.Projection = append(o.Projection, k.Field)
+		}
+	}
+
+	p.reverse = p.before
+	o.Sort = make([]SortField, 0, len(p.keys))
+	for _, k := range p.keys {
+		o.Sort = append(o.Sort, SortField{Field: k.Field, Desc: k.Desc != p.reverse})
+	}
+	o.Limit = p.limit + 1
+	o.Skip = 0
+	p.opts = o
+
+	token := q.After
+	if p.before {
+		token = q.Before
+	}
+	if token != "" {
+		values, err := p.decode(token)
+		if err != nil {
+			return nil, NewError(err, ErrParameterError)
+		}
+		p.filter = p.keysetFilter(values)
+	}
+	return p, nil
+}
+
+// FindOptions returns the options of the keyset query.
+func (p *cursorPlan) FindOptions() []FindOption {
+	return []FindOption{func(o *FindOptions) { *o = *p.opts }}
+}
+
+// keysetFilter selects the rows past values in the order of the query:
+// (k1 > v1) or (k1 = v1 and k2 > v2) and so on.
+func (p *cursorPlan) keysetFilter(values bson.A) bson.M {
+	or := bson.A{}
+	for i, k := range p.keys {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[p.keys[j].Field] = values[j]
+		}
+		op := "$gt"
+		if k.Desc != p.reverse {
+			op = "$lt"
+		}
+		clause[k.Field] = bson.M{op: values[i]}
+		or = append(or, clause)
+	}
+
+	keyset := bson.M{"$or": or}
+	if p.filter == nil {
+		return keyset
+	}
+	return bson.M{"$and": bson.A{p.filter, keyset}}
+}
+
+func (p *cursorPlan) signature() []string {
+	sig := make([]string, 0, len(p.keys))
+	for _, k := range p.keys {
+		if k.Desc {
+			sig = append(sig, "-"+k.Field)
+		} else {
+			sig = append(sig, k.Field)
+		}
+	}
+	return sig
+}
+
+func (p *cursorPlan) encode(row any) (string, error) {
+	doc, err := toBsonM(row)
+	if err != nil {
+		return "", err
+	}
+
+	t := cursorToken{Sort: p.signature()}
+	for _, k := range p.keys {
+		var v any
+		if vs, ok := lookupPath(doc, strings.Split(k.Field, ".")); ok && len(vs) > 0 {
+			v = vs[0]
+		}
+		t.Values = append(t.Values, v)
+	}
+
+	b, err := bson.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *cursorPlan) decode(token string) (bson.A, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("cursor: malformed token")
+	}
+
+	var t cursorToken
+	if err := bson.Unmarshal(b, &t); err != nil {
+		return nil, errors.New("cursor: malformed token")
+	}
+	if strings.Join(t.Sort, ",") != strings.Join(p.signature(), ",") || len(t.Values) != len(p.keys) {
+		return nil, errors.New("cursor: token does not match the sort")
+	}
+	// the token comes from the client, a document such as {$ne: null} would
+	// turn into a query operator of the keyset filter
+	for _, v := range t.Values {
+		switch v.(type) {
+		case nil, string, bool, int32, int64, float64, primitive.DateTime, primitive.ObjectID, primitive.Decimal128:
+		default:
+			return nil, errors.New("cursor: malformed token")
+		}
+	}
+	return t.Values, nil
+}
+
+// cursorResult trims the extra row read to detect a further page, restores
+// the order of a reversed query and encodes the boundary tokens.
+func cursorResult[T any](p *cursorPlan, rows []T) ([]T, CursorPage, error) {
+	page := CursorPage{Total: -1}
+
+	more := int64(len(rows)) > p.limit
+	if more {
+		rows = rows[:p.limit]
+	}
+	if p.reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+		page.HasPrev, page.HasNext = more, true
+	} else {
+		page.HasNext, page.HasPrev = more, p.after
+	}
+
+	if len(rows) == 0 {
+		return rows, page, nil
+	}
+
+	var err error
+	if page.HasNext {
+		if page.Next, err = p.encode(rows[len(rows)-1]); err != nil {
+			return nil, page, err
+		}
+	}
+	if page.HasPrev {
+		if page.Prev, err = p.encode(rows[0]); err != nil {
+			return nil, page, err
+		}
+	}
+	return rows, page, nil
+}
@@ -0,0 +1,90 @@
+package hin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+type cursorModel struct {
+	ID        string    `bson:"_id"`
+	Rank      int       `bson:"rank"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func cursorIDs(rows []cursorModel) string {
+	s := ""
+	for _, r := range rows {
+		s += r.ID
+	}
+	return s
+}
+
+func TestCursorPaging(t *testing.T) {
+	ctx := context.Background()
+	dao := &testDAO[cursorModel]{}
+	now := time.Now().Truncate(time.Millisecond)
+	dao.InsertMany(ctx, []cursorModel{
+		{ID: "a", Rank: 1, CreatedAt: now},
+		{ID: "b", Rank: 2, CreatedAt: now},
+		{ID: "c", Rank: 2, CreatedAt: now.Add(time.Second)},
+		{ID: "d", Rank: 3, CreatedAt: now.Add(time.Second)},
+		{ID: "e", Rank: 4, CreatedAt: now.Add(2 * time.Second)},
+	})
+
+	// newest first, ties broken by _id in the same direction
+	rows, page, dr := dao.CursorPaging(ctx, nil, CursorQuery{Count: 2})
+	if dr.Error != nil || cursorIDs(rows) != "ed" || !page.HasNext || page.HasPrev || page.Total != 5 {
+		t.Fatalf("first page = %s %+v %v", cursorIDs(rows), page, dr.Error)
+	}
+
+	rows, page, _ = dao.CursorPaging(ctx, nil, CursorQuery{After: page.Next, Count: 2})
+	if cursorIDs(rows) != "cb" || !page.HasNext || !page.HasPrev {
+		t.Fatalf("second page = %s %+v", cursorIDs(rows), page)
+	}
+	second := page
+
+	rows, page, _ = dao.CursorPaging(ctx, nil, CursorQuery{After: page.Next, Count: 2, SkipTotal: true})
+	if cursorIDs(rows) != "a" || page.HasNext || !page.HasPrev || page.Total != -1 {
+		t.Fatalf("last page = %s %+v", cursorIDs(rows), page)
+	}
+
+	rows, page, _ = dao.CursorPaging(ctx, nil, CursorQuery{Before: second.Prev, Count: 2})
+	if cursorIDs(rows) != "ed" || !page.HasNext || page.HasPrev {
+		t.Fatalf("page before the second = %s %+v", cursorIDs(rows), page)
+	}
+
+	// a filter and a sort of the criteria are kept
+	filter := Where("rank").Gte(2).OrderBy("rank")
+	rows, page, _ = dao.CursorPaging(ctx, filter.Mgo(), CursorQuery{Count: 2}, filter.FindOptions()...)
+	if cursorIDs(rows) != "bc" || page.Total != 4 {
+		t.Fatalf("sorted page = %s %+v", cursorIDs(rows), page)
+	}
+	rows, _, _ = dao.CursorPaging(ctx, filter.Mgo(), CursorQuery{After: page.Next, Count: 2}, filter.FindOptions()...)
+	if cursorIDs(rows) != "de" {
+		t.Fatalf("sorted second page = %s", cursorIDs(rows))
+	}
+
+	var e Error
+	if _, _, dr := dao.CursorPaging(ctx, nil, CursorQuery{After: page.Next}); !errors.As(dr.Error, &e) {
+		t.Errorf("a token of another sort = %v", dr.Error)
+	}
+	if _, _, dr := dao.CursorPaging(ctx, nil, CursorQuery{After: "x", Before: "y"}); dr.Error == nil {
+		t.Error("after and before together must fail")
+	}
+
+	// a forged token must not smuggle query operators into the filter
+	_, first, _ := dao.CursorPaging(ctx, nil, CursorQuery{Count: 2})
+	raw, _ := base64.RawURLEncoding.DecodeString(first.Next)
+	var token cursorToken
+	bson.Unmarshal(raw, &token)
+	token.Values[0] = bson.M{"$ne": nil}
+	raw, _ = bson.Marshal(token)
+	forged := base64.RawURLEncoding.EncodeToString(raw)
+	if rows, _, dr := dao.CursorPaging(ctx, nil, CursorQuery{After: forged}); !errors.As(dr.Error, &e) || len(rows) != 0 {
+		t.Errorf("forged token = %d rows, %v", len(rows), dr.Error)
+	}
+}
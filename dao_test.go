@@ -0,0 +1,189 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+	"strings"
+)
+
+// testDAO keeps documents in memory and evaluates filters with mgoMatch, it
+// covers what the tests of the DAO users need.
+type testDAO[T any] struct {
+	docs []bson.M
+	// pipeline is the last pipeline passed to Aggregate
+	pipeline any
+}
+
+func (d *testDAO[T]) Insert(ctx context.Context, model T) *MDR {
+	return d.InsertMany(ctx, []T{model})
+}
+
+func (d *testDAO[T]) InsertMany(ctx context.Context, model []T) *MDR {
+	r := new(MDR)
+	for _, m := range model {
+		doc, err := toBsonM(m)
+		if err != nil {
+			return newErrMDR(err)
+		}
+		d.docs = append(d.docs, doc)
+		r.setID(doc["_id"])
+	}
+	return r.SetCount(int64(len(model)))
+}
+
+func (d *testDAO[T]) Find(ctx context.Context, filter any, opts ...FindOption) ([]T, *MDR) {
+	o := newFindOptions(opts)
+
+	var docs []bson.M
+	for _, doc := range d.docs {
+		if ok, err := mgoMatch(doc, filter); err != nil {
+			return nil, newErrMDR(err)
+		} else if ok {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, s := range o.Sort {
+			a, _ := lookupPath(docs[i], strings.Split(mgoField(s.Field), "."))
+			b, _ := lookupPath(docs[j], strings.Split(mgoField(s.Field), "."))
+			var x, y any
+			if len(a) > 0 {
+				x = a[0]
+			}
+			if len(b) > 0 {
+				y = b[0]
+			}
+			if c, _ := compareValues(x, y); c != 0 {
+				return (c < 0) != s.Desc
+			}
+		}
+		return false
+	})
+
+	if o.Skip > 0 {
+		docs = docs[min(o.Skip, int64(len(docs))):]
+	}
+	if o.Limit > 0 && int64(len(docs)) > o.Limit {
+		docs = docs[:o.Limit]
+	}
+
+	r := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var m T
+		b, _ := bson.Marshal(doc)
+		if err := bson.Unmarshal(b, &m); err != nil {
+			return nil, newErrMDR(err)
+		}
+		r = append(r, m)
+	}
+	return r, new(MDR).SetCount(int64(len(r)))
+}
+
+func (d *testDAO[T]) Each(ctx context.Context, filter any, fn func(T) error, opts ...FindOption) error {
+	r, dr := d.Find(ctx, filter, opts...)
+	if dr.Error != nil {
+		return dr.Error
+	}
+	for _, m := range r {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *testDAO[T]) Iter(ctx context.Context, filter any, opts ...FindOption) func(yield func(T, error) bool) {
+	return iterOf(func(fn func(T) error) error {
+		return d.Each(ctx, filter, fn, opts...)
+	})
+}
+
+func (d *testDAO[T]) FindOne(ctx context.Context, filter any, opts ...FindOption) (T, *MDR) {
+	var m T
+	r, dr := d.Find(ctx, filter, append(opts, WithLimit(1))...)
+	if dr.Error != nil {
+		return m, dr
+	}
+	if len(r) == 0 {
+		return m, newErrMDR(mongo.ErrNoDocuments)
+	}
+	return r[0], dr
+}
+
+func (d *testDAO[T]) Update(ctx context.Context, filter any, model any) *MDR {
+	set, err := toBsonM(model)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	for _, doc := range d.docs {
+		if ok, err := mgoMatch(doc, filter); err != nil {
+			return newErrMDR(err)
+		} else if ok {
+			for k, v := range set {
+				doc[k] = v
+			}
+			return new(MDR).SetCount(1)
+		}
+	}
+	return new(MDR)
+}
+
+func (d *testDAO[T]) UpdateById(ctx context.Context, id any, model any) *MDR {
+	return d.Update(ctx, bson.M{"_id": id}, model)
+}
+
+func (d *testDAO[T]) UpdateMany(ctx context.Context, filter any, model any) *MDR {
+	return newErrMDR(errors.New("not implemented"))
+}
+
+func (d *testDAO[T]) BulkWrite(ctx context.Context, ops []BulkOp, ordered bool) *MDR {
+	return newErrMDR(errors.New("not implemented"))
+}
+
+func (d *testDAO[T]) Aggregate(ctx context.Context, pipeline any, results any) *MDR {
+	d.pipeline = pipeline
+	return new(MDR)
+}
+
+func (d *testDAO[T]) CreateIndexes(ctx context.Context, models []mongo.IndexModel) *MDR {
+	return new(MDR)
+}
+
+func (d *testDAO[T]) Paging(ctx context.Context, filter any, paging PagingQuery, opts ...FindOption) ([]T, int64, *MDR) {
+	return nil, 0, newErrMDR(errors.New("not implemented"))
+}
+
+func (d *testDAO[T]) CursorPaging(ctx context.Context, filter any, cursor CursorQuery, opts ...FindOption) ([]T, CursorPage, *MDR) {
+	plan, err := newCursorPlan(filter, cursor, opts)
+	if err != nil {
+		return nil, CursorPage{}, newErrMDR(err)
+	}
+	rows, dr := d.Find(ctx, plan.filter, plan.FindOptions()...)
+	if dr.Error != nil {
+		return nil, CursorPage{}, dr
+	}
+	rows, page, err := cursorResult(plan, rows)
+	if err != nil {
+		return nil, page, newErrMDR(err)
+	}
+	if !cursor.SkipTotal {
+		page.Total, _ = d.Count(ctx, filter)
+	}
+	return rows, page, new(MDR).SetCount(int64(len(rows)))
+}
+
+func (d *testDAO[T]) Count(ctx context.Context, filter any) (int64, error) {
+	r, dr := d.Find(ctx, filter)
+	return int64(len(r)), dr.Error
+}
+
+func (d *testDAO[T]) Delete(ctx context.Context, filter any) *MDR {
+	return newErrMDR(errors.New("not implemented"))
+}
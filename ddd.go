@@ -2,6 +2,7 @@ package hin
 
 import (
 	"context"
+	"errors"
 	"github.com/jinzhu/copier"
 	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,6 +10,7 @@ import (
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 	"reflect"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,7 @@ type BaseService[E any] interface {
 	Find(ctx context.Context, query any) ([]E, error)
 	FindOne(ctx context.Context, query any) (E, error)
 	Paging(ctx context.Context, query any, paging PagingQuery) (PagingDTO, error)
+	CursorPaging(ctx context.Context, query any, cursor CursorQuery) (CursorPagingDTO, error)
 }
 
 type BaseSrv[E any] struct {
@@ -62,6 +65,27 @@ func (s *BaseSrv[E]) Paging(ctx context.Context, query any, paging PagingQuery)
 	return dto, nil
 }
 
+func (s *BaseSrv[E]) CursorPaging(ctx context.Context, query any, cursor CursorQuery) (CursorPagingDTO, error) {
+	dto := CursorPagingDTO{
+		Count: cursor.Count,
+	}
+
+	items, page, r := s.Repo.CursorPaging(ctx, Criteria(query), cursor)
+	if r.Error != nil {
+		s.Logger.Error("baseSrv.CursorPaging", zap.Error(r.Error))
+		return dto, r.Error
+	}
+
+	dto.Items = items
+	dto.Next, dto.Prev = page.Next, page.Prev
+	dto.HasNext, dto.HasPrev = page.HasNext, page.HasPrev
+	if page.Total >= 0 {
+		dto.Total = &page.Total
+	}
+
+	return dto, nil
+}
+
 func (s *BaseSrv[E]) Remove(ctx context.Context, query any) error {
 	return s.Repo.Remove(ctx, Criteria(query)).Error
 }
@@ -76,23 +100,164 @@ type BaseConverter[M any, E any] interface {
 type BaseDAO[T any] interface {
 	Insert(ctx context.Context, model T) *MDR
 	InsertMany(ctx context.Context, model []T) *MDR
-	Find(ctx context.Context, filter any) ([]T, *MDR)
-	FindOne(ctx context.Context, filter any) (T, *MDR)
+	Find(ctx context.Context, filter any, opts ...FindOption) ([]T, *MDR)
+	Each(ctx context.Context, filter any, fn func(T) error, opts ...FindOption) error
+	Iter(ctx context.Context, filter any, opts ...FindOption) func(yield func(T, error) bool)
+	FindOne(ctx context.Context, filter any, opts ...FindOption) (T, *MDR)
 	Update(ctx context.Context, filter any, model any) *MDR
 	UpdateById(ctx context.Context, id any, model any) *MDR
-	UpdateMany(ctx context.Context, filter any, model []any) *MDR
+	UpdateMany(ctx context.Context, filter any, model any) *MDR
+	BulkWrite(ctx context.Context, ops []BulkOp, ordered bool) *MDR
+	Aggregate(ctx context.Context, pipeline any, results any) *MDR
 	CreateIndexes(ctx context.Context, models []mongo.IndexModel) *MDR
-	Paging(ctx context.Context, filter any, paging PagingQuery) ([]T, int64, *MDR)
+	Paging(ctx context.Context, filter any, paging PagingQuery, opts ...FindOption) ([]T, int64, *MDR)
+	CursorPaging(ctx context.Context, filter any, cursor CursorQuery, opts ...FindOption) ([]T, CursorPage, *MDR)
 	Count(ctx context.Context, filter any) (int64, error)
+	Delete(ctx context.Context, filter any) *MDR
+}
+
+type SortField struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc,omitempty"`
+}
+
+// FindOptions is the backend neutral form of sort, projection and window
+// applied by BaseDAO reads. Without a sort the newest documents come first.
+type FindOptions struct {
+	Sort       []SortField
+	Projection []string
+	Limit      int64
+	Skip       int64
+	// BatchSize is the number of documents fetched per round trip by streams.
+	BatchSize int32
+}
+
+type FindOption func(*FindOptions)
+
+func WithSort(fields ...string) FindOption {
+	return func(o *FindOptions) {
+		o.Sort = append(o.Sort, parseSortFields(fields...)...)
+	}
+}
+
+func WithProjection(fields ...string) FindOption {
+	return func(o *FindOptions) {
+		o.Projection = append(o.Projection, fields...)
+	}
+}
+
+func WithLimit(n int64) FindOption {
+	return func(o *FindOptions) {
+		o.Limit = n
+	}
+}
+
+func WithSkip(n int64) FindOption {
+	return func(o *FindOptions) {
+		o.Skip = n
+	}
+}
+
+func WithBatchSize(n int32) FindOption {
+	return func(o *FindOptions) {
+		o.BatchSize = n
+	}
+}
+
+func newFindOptions(opts []FindOption) *FindOptions {
+	o := new(FindOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.Sort) == 0 {
+		o.Sort = []SortField{{Field: "created_at", Desc: true}}
+	}
+	return o
+}
+
+func (o *FindOptions) mgoSort() bson.D {
+	d := bson.D{}
+	for _, s := range o.Sort {
+		order := 1
+		if s.Desc {
+			order = -1
+		}
+		d = append(d, bson.E{Key: mgoField(s.Field), Value: order})
+	}
+	return d
+}
+
+func (o *FindOptions) mgoProjection() bson.D {
+	if len(o.Projection) == 0 {
+		return nil
+	}
+	d := bson.D{}
+	for _, f := range o.Projection {
+		d = append(d, bson.E{Key: mgoField(f), Value: 1})
+	}
+	return d
+}
+
+// mgoFind converts the options for Collection.Find.
+func (o *FindOptions) mgoFind() *mopt.FindOptions {
+	fo := new(mopt.FindOptions)
+	fo.SetSort(o.mgoSort())
+	if p := o.mgoProjection(); p != nil {
+		fo.SetProjection(p)
+	}
+	if o.Limit > 0 {
+		fo.SetLimit(o.Limit)
+	}
+	if o.Skip > 0 {
+		fo.SetSkip(o.Skip)
+	}
+	if o.BatchSize > 0 {
+		fo.SetBatchSize(o.BatchSize)
+	}
+	return fo
+}
+
+func (o *FindOptions) mgoFindOne() *mopt.FindOneOptions {
+	fo := new(mopt.FindOneOptions)
+	fo.SetSort(o.mgoSort())
+	if p := o.mgoProjection(); p != nil {
+		fo.SetProjection(p)
+	}
+	if o.Skip > 0 {
+		fo.SetSkip(o.Skip)
+	}
+	return fo
+}
+
+// parseSortFields reads "-field" as descending and "field" or "+field" as ascending.
+func parseSortFields(fields ...string) []SortField {
+	sorts := make([]SortField, 0, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "" || f == "-" || f == "+":
+			continue
+		case strings.HasPrefix(f, "-"):
+			sorts = append(sorts, SortField{Field: f[1:], Desc: true})
+		default:
+			sorts = append(sorts, SortField{Field: strings.TrimPrefix(f, "+")})
+		}
+	}
+	return sorts
 }
 
 type BaseRepository[E any] interface {
 	Save(ctx context.Context, entity E) *MDR
 	Exist(ctx context.Context, filter CriteriaBuilder) bool
 	Remove(ctx context.Context, filter CriteriaBuilder) *MDR
+	Restore(ctx context.Context, filter CriteriaBuilder) *MDR
+	HardRemove(ctx context.Context, filter CriteriaBuilder) *MDR
 	Find(ctx context.Context, filter CriteriaBuilder) ([]E, *MDR)
 	FindOne(ctx context.Context, filter CriteriaBuilder) (E, *MDR)
+	Each(ctx context.Context, filter CriteriaBuilder, fn func(E) error) error
+	Iter(ctx context.Context, filter CriteriaBuilder) func(yield func(E, error) bool)
 	Paging(ctx context.Context, filter CriteriaBuilder, paging PagingQuery) ([]E, int64, *MDR)
+	CursorPaging(ctx context.Context, filter CriteriaBuilder, cursor CursorQuery) ([]E, CursorPage, *MDR)
 	Count(ctx context.Context, filter CriteriaBuilder) int64
 }
 
@@ -101,6 +266,14 @@ type MDR struct {
 	Count int64
 	Error error
 	IDs   []string
+
+	// set by BulkWrite
+	Inserted int64
+	Matched  int64
+	Modified int64
+	Upserted int64
+	Deleted  int64
+	Ops      []BulkOpResult
 }
 
 func (m *MDR) ID() string {
@@ -138,6 +311,9 @@ type BaseRepo[M any, E any] struct {
 	Logger        *Logger
 	Cv            BaseConverter[M, E]
 	TypeConverter []copier.TypeConverter
+	Events        *EventBus
+	Outbox        *Outbox
+	History       *History
 }
 
 func NewBaseRepository[M any, E any](
@@ -149,6 +325,9 @@ func NewBaseRepository[M any, E any](
 		logger,
 		nil,
 		make([]copier.TypeConverter, 0),
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -162,6 +341,33 @@ func (r *BaseRepo[M, E]) WithTypeConverter(tc []copier.TypeConverter) *BaseRepo[
 	return r
 }
 
+// WithEventBus dispatches the events recorded on saved entities to bus.
+func (r *BaseRepo[M, E]) WithEventBus(bus *EventBus) *BaseRepo[M, E] {
+	r.Events = bus
+	return r
+}
+
+// WithOutbox stores the events recorded on saved entities in outbox, in the
+// transaction writing the entity.
+func (r *BaseRepo[M, E]) WithOutbox(outbox *Outbox) *BaseRepo[M, E] {
+	r.Outbox = outbox
+	return r
+}
+
+// WithHistory records the field changes made through the repository in
+// history, in the transaction of the change.
+func (r *BaseRepo[M, E]) WithHistory(history *History) *BaseRepo[M, E] {
+	r.History = history
+	return r
+}
+
+func (r *BaseRepo[M, E]) transaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	if r.Outbox != nil {
+		return r.Outbox.transaction(ctx, fn)
+	}
+	return r.History.transaction(ctx, fn)
+}
+
 func (r *BaseRepo[M, E]) ToEntities(ms []M) []E {
 	if r.Cv != nil {
 		return r.Cv.ToEntities(ms)
@@ -212,38 +418,244 @@ func (r *BaseRepo[M, E]) ToEntity(m M) E {
 	return e
 }
 
+// Save inserts or updates the entity. After a successful write the events
+// recorded on it are added to the outbox and published with the context of
+// the write, so handlers run inside the transaction when there is one. With
+// an outbox the write, the outbox records and the handlers share a transaction
+// and a handler error rolls them back according to the bus policy. The events
+// are cleared from the entity once everything succeeded.
 func (r *BaseRepo[M, E]) Save(ctx context.Context, entity E) *MDR {
+	var events []DomainEvent
+	src := eventSourceOf(&entity)
+	if src != nil && (r.Events != nil || r.Outbox != nil) {
+		events = src.Events()
+	}
+	if len(events) == 0 && r.History == nil {
+		return r.save(ctx, entity)
+	}
+
+	var dr *MDR
+	err := r.transaction(ctx, func(txCtx context.Context) error {
+		if dr = r.save(txCtx, entity); dr.Error != nil {
+			return dr.Error
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		if r.Outbox != nil {
+			var id string
+			if len(dr.IDs) > 0 {
+				id = dr.ID()
+			}
+			if err := r.Outbox.Add(txCtx, id, events...); err != nil {
+				return err
+			}
+		}
+		if r.Events != nil {
+			return r.Events.Publish(txCtx, events...)
+		}
+		return nil
+	})
+	if err != nil {
+		if dr == nil {
+			dr = new(MDR)
+		}
+		dr.Error = err
+		return dr
+	}
+
+	if len(events) > 0 {
+		src.ClearEvents()
+	}
+	return dr
+}
+
+func (r *BaseRepo[M, E]) save(ctx context.Context, entity E) *MDR {
+	tenant, err := tenantOf[M](ctx)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
 	m := r.ToModel(entity)
 	rv := reflect.ValueOf(&m)
+	actor := ActorOf(ctx)
+	tenantID := rv.Elem().FieldByName("TenantID")
 	if v := rv.Elem().FieldByName("ID"); v.String() != "00000000000000000000" {
 		// before update data set updated_at
 		if v := rv.Elem().FieldByName("UpdatedAt"); v.IsValid() {
 			v.Set(reflect.ValueOf(time.Now()))
 		}
-		return r.Dao.UpdateById(ctx, v.String(), m)
+		setAudit(rv, "UpdatedBy", actor)
+
+		// a model of another tenant is not found
+		byID := bson.M{"_id": v.String()}
+		if tenant != "" {
+			byID["tenant_id"] = tenant
+		}
+
+		var before any
+		if r.History != nil {
+			if old, dr := r.Dao.FindOne(ctx, byID); dr.Error == nil {
+				before = old
+			} else if !errors.Is(dr.Error, mongo.ErrNoDocuments) {
+				return dr
+			}
+		}
+
+		var dr *MDR
+		if ver := rv.Elem().FieldByName("Version"); ver.IsValid() && ver.CanInt() && ver.Int() > 0 {
+			dr = r.updateVersioned(ctx, byID, ver, &m)
+		} else if tenant != "" {
+			dr = r.updateTenant(ctx, byID, m)
+		} else if set, err := r.updateOf(m); err != nil {
+			dr = newErrMDR(err)
+		} else {
+			dr = r.Dao.UpdateById(ctx, v.String(), set)
+		}
+		if dr.Error == nil && before != nil {
+			dr.Error = r.record(ctx, HistoryUpdate, v.String(), before)
+		}
+		return dr
 	} else {
+		if tenant != "" && tenantID.Kind() == reflect.String {
+			tenantID.SetString(tenant)
+		}
 		if v := rv.Elem().FieldByName("CreatedAt"); v.IsValid() {
 			v.Set(reflect.ValueOf(time.Now()))
 		}
 		if v := rv.Elem().FieldByName("UpdatedAt"); v.IsValid() {
 			v.Set(reflect.ValueOf(time.Now()))
 		}
+		setAudit(rv, "CreatedBy", actor)
+		setAudit(rv, "UpdatedBy", actor)
+		if ver := rv.Elem().FieldByName("Version"); ver.IsValid() && ver.CanInt() && ver.Int() == 0 {
+			ver.SetInt(1)
+		}
 		v.SetString(NewID().String())
-		return r.Dao.Insert(ctx, m)
+		dr := r.Dao.Insert(ctx, m)
+		if dr.Error == nil && r.History != nil {
+			dr.Error = r.History.Record(ctx, HistoryCreate, v.String(), nil, m)
+		}
+		return dr
+	}
+}
+
+// record reads the model with id back after a change and stores the change
+// from before in the history.
+func (r *BaseRepo[M, E]) record(ctx context.Context, action HistoryAction, id string, before any) error {
+	after, dr := r.Dao.FindOne(ctx, bson.M{"_id": id})
+	if dr.Error != nil {
+		return dr.Error
+	}
+	return r.History.Record(ctx, action, id, before, after)
+}
+
+// updateVersioned only writes the model when the stored version is still the
+// one it was read with, and moves the version on.
+func (r *BaseRepo[M, E]) updateVersioned(ctx context.Context, byID bson.M, ver reflect.Value, m *M) *MDR {
+	current := ver.Int()
+	ver.SetInt(current + 1)
+
+	filter := bson.M{"version": current}
+	for k, v := range byID {
+		filter[k] = v
+	}
+	set, err := r.updateOf(*m)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	dr := r.Dao.Update(ctx, filter, set)
+	if dr.Error != nil {
+		return dr
+	}
+
+	if dr.Count == 0 {
+		if n, err := r.Dao.Count(ctx, byID); err != nil {
+			return newErrMDR(err)
+		} else if n == 0 {
+			return newErrMDR(NewError(nil, ErrNotFound))
+		}
+		return newErrMDR(NewError(nil, ErrVersionConflict))
+	}
+	return dr.setID(byID["_id"])
+}
+
+// updateOf returns the $set of an update of m. The tenant of a stored model
+// never changes, so tenant_id is left out whatever its tags.
+func (r *BaseRepo[M, E]) updateOf(m M) (any, error) {
+	if !hasField[M]("TenantID") {
+		return m, nil
+	}
+	set, err := toBsonM(m)
+	if err != nil {
+		return nil, err
+	}
+	delete(set, "tenant_id")
+	return set, nil
+}
+
+// updateTenant writes the model only when it belongs to the tenant of byID.
+func (r *BaseRepo[M, E]) updateTenant(ctx context.Context, byID bson.M, m M) *MDR {
+	set, err := r.updateOf(m)
+	if err != nil {
+		return newErrMDR(err)
 	}
+	dr := r.Dao.Update(ctx, byID, set)
+	if dr.Error != nil {
+		return dr
+	}
+
+	if dr.Count == 0 {
+		if n, err := r.Dao.Count(ctx, byID); err != nil {
+			return newErrMDR(err)
+		} else if n == 0 {
+			return newErrMDR(NewError(nil, ErrNotFound))
+		}
+	}
+	return dr.setID(byID["_id"])
 }
 
 func (r *BaseRepo[M, E]) Find(ctx context.Context, filter CriteriaBuilder) ([]E, *MDR) {
-	if ms, dr := r.Dao.Find(ctx, filter.Mgo()); dr.Error != nil {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return nil, newErrMDR(err)
+	}
+
+	if ms, dr := r.Dao.Find(ctx, filter.Mgo(), filter.FindOptions()...); dr.Error != nil {
 		return nil, dr
 	} else {
 		return r.ToEntities(ms), dr
 	}
 }
 
+// Each calls fn with the entities one by one as they are read, it stops at
+// the first error of fn, of the read or of ctx.
+func (r *BaseRepo[M, E]) Each(ctx context.Context, filter CriteriaBuilder, fn func(E) error) error {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return r.Dao.Each(ctx, filter.Mgo(), func(m M) error {
+		return fn(r.ToEntity(m))
+	}, filter.FindOptions()...)
+}
+
+// Iter returns the entities matching the filter as an iterator, a failed
+// query is yielded as an error.
+func (r *BaseRepo[M, E]) Iter(ctx context.Context, filter CriteriaBuilder) func(yield func(E, error) bool) {
+	return iterOf(func(fn func(E) error) error {
+		return r.Each(ctx, filter, fn)
+	})
+}
+
 func (r *BaseRepo[M, E]) FindOne(ctx context.Context, filter CriteriaBuilder) (E, *MDR) {
-	if m, dr := r.Dao.FindOne(ctx, filter.Mgo()); dr.Error != nil {
-		var e E
+	var e E
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return e, newErrMDR(err)
+	}
+
+	if m, dr := r.Dao.FindOne(ctx, filter.Mgo(), filter.FindOptions()...); dr.Error != nil {
 		return e, dr
 	} else {
 		return r.ToEntity(m), dr
@@ -251,15 +663,159 @@ func (r *BaseRepo[M, E]) FindOne(ctx context.Context, filter CriteriaBuilder) (E
 }
 
 func (r *BaseRepo[M, E]) Paging(ctx context.Context, filter CriteriaBuilder, paging PagingQuery) ([]E, int64, *MDR) {
-	if ms, count, dr := r.Dao.Paging(ctx, filter.Mgo(), paging); dr.Error != nil {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return nil, 0, newErrMDR(err)
+	}
+
+	if ms, count, dr := r.Dao.Paging(ctx, filter.Mgo(), paging, filter.FindOptions()...); dr.Error != nil {
 		return nil, 0, dr
 	} else {
 		return r.ToEntities(ms), count, dr
 	}
 }
 
+// CursorPaging reads a page in the sort of the criteria, its limit and skip
+// are replaced by the cursor.
+func (r *BaseRepo[M, E]) CursorPaging(ctx context.Context, filter CriteriaBuilder, cursor CursorQuery) ([]E, CursorPage, *MDR) {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return nil, CursorPage{}, newErrMDR(err)
+	}
+
+	if ms, page, dr := r.Dao.CursorPaging(ctx, filter.Mgo(), cursor, filter.FindOptions()...); dr.Error != nil {
+		return nil, page, dr
+	} else {
+		return r.ToEntities(ms), page, dr
+	}
+}
+
 func (r *BaseRepo[M, E]) Remove(ctx context.Context, filter CriteriaBuilder) *MDR {
-	return r.Dao.Update(ctx, filter.Mgo(), bson.M{"deleted_at": time.Now()})
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	set := bson.M{"deleted_at": time.Now()}
+	if actor := ActorOf(ctx); actor != "" && hasField[M]("DeletedBy") {
+		set["deleted_by"] = actor
+	}
+	return r.update(ctx, HistoryRemove, filter.Mgo(), set, false)
+}
+
+// Restore clears deleted_at of every soft deleted document matching the
+// filter, like HardRemove deletes all of them. Without an explicit scope the
+// filter is applied to soft deleted documents only.
+func (r *BaseRepo[M, E]) Restore(ctx context.Context, filter CriteriaBuilder) *MDR {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	if filter.Scope == ScopeDefault {
+		filter = filter.OnlyDeleted()
+	}
+	set := bson.M{"deleted_at": nil}
+	if hasField[M]("DeletedBy") {
+		set["deleted_by"] = nil
+	}
+	return r.update(ctx, HistoryRestore, filter.Mgo(), set, true)
+}
+
+// update sets fields of the first document matching filter, or of all of
+// them with many. With a history the documents are looked up first and
+// updated by their id, so that the change of exactly those documents is
+// recorded.
+func (r *BaseRepo[M, E]) update(ctx context.Context, action HistoryAction, filter any, set bson.M, many bool) *MDR {
+	if r.History == nil {
+		if many {
+			return r.Dao.UpdateMany(ctx, filter, set)
+		}
+		return r.Dao.Update(ctx, filter, set)
+	}
+
+	dr := new(MDR)
+	err := r.transaction(ctx, func(txCtx context.Context) error {
+		var ms []M
+		if many {
+			found, fdr := r.Dao.Find(txCtx, filter)
+			if fdr.Error != nil {
+				return fdr.Error
+			}
+			ms = found
+		} else if m, fdr := r.Dao.FindOne(txCtx, filter); fdr.Error == nil {
+			ms = append(ms, m)
+		} else if !errors.Is(fdr.Error, mongo.ErrNoDocuments) {
+			return fdr.Error
+		}
+
+		for _, before := range ms {
+			id := modelID(before)
+			udr := r.Dao.Update(txCtx, bson.M{"_id": id}, set)
+			if udr.Error != nil {
+				return udr.Error
+			}
+			dr.Count += udr.Count
+			dr.IDs = append(dr.IDs, id)
+			if err := r.record(txCtx, action, id, before); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dr.Error = err
+	}
+	return dr
+}
+
+// HardRemove permanently deletes every document matching the filter, use
+// WithDeleted or OnlyDeleted to reach soft deleted ones.
+func (r *BaseRepo[M, E]) HardRemove(ctx context.Context, filter CriteriaBuilder) *MDR {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	if r.History == nil {
+		return r.Dao.Delete(ctx, filter.Mgo())
+	}
+
+	dr := new(MDR)
+	err = r.transaction(ctx, func(txCtx context.Context) error {
+		ms, fdr := r.Dao.Find(txCtx, filter.Mgo())
+		if fdr.Error != nil {
+			return fdr.Error
+		}
+		if dr = r.Dao.Delete(txCtx, filter.Mgo()); dr.Error != nil {
+			return dr.Error
+		}
+		for _, m := range ms {
+			if err := r.History.Record(txCtx, HistoryDelete, modelID(m), m, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dr.Error = err
+	}
+	return dr
+}
+
+func modelID[M any](m M) string {
+	if v := reflect.Indirect(reflect.ValueOf(m)); v.Kind() == reflect.Struct {
+		if id := v.FieldByName("ID"); id.IsValid() && id.Kind() == reflect.String {
+			return id.String()
+		}
+	}
+	return ""
+}
+
+// Purge permanently deletes documents soft deleted longer than retention ago.
+func (r *BaseRepo[M, E]) Purge(ctx context.Context, retention time.Duration) *MDR {
+	filter, err := r.scope(ctx, Where("deleted_at").Lt(time.Now().Add(-retention)).WithDeleted())
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return r.Dao.Delete(ctx, filter.Mgo())
 }
 
 func (r *BaseRepo[M, E]) Exist(ctx context.Context, filter CriteriaBuilder) bool {
@@ -268,10 +824,41 @@ func (r *BaseRepo[M, E]) Exist(ctx context.Context, filter CriteriaBuilder) bool
 }
 
 func (r *BaseRepo[M, E]) Count(ctx context.Context, filter CriteriaBuilder) int64 {
+	filter, err := r.scope(ctx, filter)
+	if err != nil {
+		r.Logger.Error("baseRepo.Count", zap.Error(err))
+		return 0
+	}
 	count, _ := r.Dao.Count(ctx, filter.Mgo())
 	return count
 }
 
+// scope checks the criteria and restricts it to the tenant of ctx.
+func (r *BaseRepo[M, E]) scope(ctx context.Context, filter CriteriaBuilder) (CriteriaBuilder, error) {
+	if err := checkCriteria[M](filter); err != nil {
+		return filter, err
+	}
+	return scopeTenant[M](ctx, filter)
+}
+
+// checkCriteria returns the error of the criteria, and in strict mode rejects
+// fields that the model M does not store.
+func checkCriteria[M any](filter CriteriaBuilder) error {
+	if filter.Error != nil {
+		return filter.Error
+	}
+	if filter.Expr != nil {
+		if err := filter.Expr.Validate(); err != nil {
+			return err
+		}
+	}
+	if CriteriaStrict() {
+		var m M
+		return filter.CheckFields(m)
+	}
+	return nil
+}
+
 type BaseMongoDAO[T any] struct {
 	Logger *Logger
 	Client *mongo.Client
@@ -308,7 +895,10 @@ func NewMongoDAO[T any](
 
 func (d *BaseMongoDAO[T]) Insert(ctx context.Context, model T) *MDR {
 	r, err := d.Col.InsertOne(ctx, model)
-	return (&MDR{Error: err}).setID(r.InsertedID)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return new(MDR).setID(r.InsertedID)
 }
 
 func (d *BaseMongoDAO[T]) InsertMany(ctx context.Context, model []T) *MDR {
@@ -317,32 +907,60 @@ func (d *BaseMongoDAO[T]) InsertMany(ctx context.Context, model []T) *MDR {
 		ms = append(ms, m)
 	}
 	r, err := d.Col.InsertMany(ctx, ms)
-	return (&MDR{Error: err}).setID(r.InsertedIDs)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return new(MDR).setID(r.InsertedIDs)
 }
 
 func (d *BaseMongoDAO[T]) Update(ctx context.Context, filter any, model any) *MDR {
 	r, err := d.Col.UpdateOne(ctx, filter, bson.M{"$set": model})
-	return (&MDR{Error: err, Count: r.ModifiedCount}).setID(r.UpsertedID)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return (&MDR{Count: r.ModifiedCount}).setID(r.UpsertedID)
 }
 
 func (d *BaseMongoDAO[T]) UpdateById(ctx context.Context, id any, model any) *MDR {
 	r, err := d.Col.UpdateByID(ctx, id, bson.M{"$set": model})
-	return (&MDR{Error: err, Count: r.ModifiedCount}).setID(id)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return (&MDR{Count: r.ModifiedCount}).setID(id)
 }
 
-func (d *BaseMongoDAO[T]) UpdateMany(ctx context.Context, filter any, model []any) *MDR {
+// UpdateMany sets the fields of model on every document matching filter.
+func (d *BaseMongoDAO[T]) UpdateMany(ctx context.Context, filter any, model any) *MDR {
 	r, err := d.Col.UpdateMany(ctx, filter, bson.M{"$set": model})
-	return (&MDR{Error: err, Count: r.ModifiedCount}).setID(r.UpsertedID)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return (&MDR{Count: r.ModifiedCount, Matched: r.MatchedCount, Modified: r.ModifiedCount}).setID(r.UpsertedID)
 }
 
-func (d *BaseMongoDAO[T]) Find(ctx context.Context, filter any) ([]T, *MDR) {
-	opts := new(mopt.FindOptions)
-	opts.SetSort(bson.D{{"created_at", -1}})
-	cur, err := d.Col.Find(ctx, filter, opts)
-	defer cur.Close(ctx)
+// BulkWrite sends mixed operations in one batch. Ordered writes stop at the
+// first failure, unordered ones run every operation; either way Ops reports
+// each operation and Error the failures.
+func (d *BaseMongoDAO[T]) BulkWrite(ctx context.Context, ops []BulkOp, ordered bool) *MDR {
+	if len(ops) == 0 {
+		return new(MDR)
+	}
+
+	models, ids, err := mgoWriteModels(ops)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	r, err := d.Col.BulkWrite(ctx, models, mopt.BulkWrite().SetOrdered(ordered))
+	return bulkResult(ops, ids, ordered, r, err)
+}
+
+func (d *BaseMongoDAO[T]) Find(ctx context.Context, filter any, opts ...FindOption) ([]T, *MDR) {
+	cur, err := d.Col.Find(ctx, filter, newFindOptions(opts).mgoFind())
 	if err != nil {
 		return nil, newErrMDR(err)
 	}
+	defer cur.Close(ctx)
 
 	r := make([]T, 0)
 	for cur.Next(ctx) {
@@ -356,10 +974,38 @@ func (d *BaseMongoDAO[T]) Find(ctx context.Context, filter any) ([]T, *MDR) {
 	return r, new(MDR).SetCount(int64(len(r)))
 }
 
-func (d *BaseMongoDAO[T]) FindOne(ctx context.Context, filter any) (T, *MDR) {
-	opts := new(mopt.FindOneOptions)
-	opts.SetSort(bson.D{{"created_at", -1}})
-	cur := d.Col.FindOne(ctx, filter, opts)
+// Each decodes the documents one at a time from the cursor instead of
+// loading them into a slice, see WithBatchSize.
+func (d *BaseMongoDAO[T]) Each(ctx context.Context, filter any, fn func(T) error, opts ...FindOption) error {
+	cur, err := d.Col.Find(ctx, filter, newFindOptions(opts).mgoFind())
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var result T
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (d *BaseMongoDAO[T]) Iter(ctx context.Context, filter any, opts ...FindOption) func(yield func(T, error) bool) {
+	return iterOf(func(fn func(T) error) error {
+		return d.Each(ctx, filter, fn, opts...)
+	})
+}
+
+func (d *BaseMongoDAO[T]) FindOne(ctx context.Context, filter any, opts ...FindOption) (T, *MDR) {
+	cur := d.Col.FindOne(ctx, filter, newFindOptions(opts).mgoFindOne())
 	var r T
 	if err := cur.Decode(&r); err != nil {
 		return r, newErrMDR(err)
@@ -368,17 +1014,16 @@ func (d *BaseMongoDAO[T]) FindOne(ctx context.Context, filter any) (T, *MDR) {
 	return r, new(MDR).SetCount(1)
 }
 
-func (d *BaseMongoDAO[T]) Paging(ctx context.Context, filter any, paging PagingQuery) ([]T, int64, *MDR) {
-	opts := new(mopt.FindOptions)
-	opts.SetSort(bson.D{{"created_at", -1}})
-	opts.SetLimit(paging.Count)
-	opts.SetSkip(paging.Count * paging.Page)
+func (d *BaseMongoDAO[T]) Paging(ctx context.Context, filter any, paging PagingQuery, opts ...FindOption) ([]T, int64, *MDR) {
+	fo := newFindOptions(opts).mgoFind()
+	fo.SetLimit(paging.Count)
+	fo.SetSkip(paging.Count * paging.Page)
 
-	cur, err := d.Col.Find(ctx, filter, opts)
-	defer cur.Close(ctx)
+	cur, err := d.Col.Find(ctx, filter, fo)
 	if err != nil {
 		return nil, 0, newErrMDR(err)
 	}
+	defer cur.Close(ctx)
 
 	r := make([]T, 0)
 	for cur.Next(ctx) {
@@ -396,6 +1041,52 @@ func (d *BaseMongoDAO[T]) Paging(ctx context.Context, filter any, paging PagingQ
 	return r, total, new(MDR).SetCount(int64(len(r)))
 }
 
+// CursorPaging reads the rows after or before the cursor by their sort key
+// instead of skipping, which stays fast on large collections and does not
+// repeat rows inserted between two pages. Rows with a missing or null sort
+// key may be left out.
+func (d *BaseMongoDAO[T]) CursorPaging(ctx context.Context, filter any, cursor CursorQuery, opts ...FindOption) ([]T, CursorPage, *MDR) {
+	plan, err := newCursorPlan(filter, cursor, opts)
+	if err != nil {
+		return nil, CursorPage{}, newErrMDR(err)
+	}
+
+	rows, dr := d.Find(ctx, plan.filter, plan.FindOptions()...)
+	if dr.Error != nil {
+		return nil, CursorPage{}, dr
+	}
+
+	rows, page, err := cursorResult(plan, rows)
+	if err != nil {
+		return nil, page, newErrMDR(err)
+	}
+
+	if !cursor.SkipTotal {
+		if filter == nil {
+			filter = bson.M{}
+		}
+		if page.Total, err = d.Col.CountDocuments(ctx, filter); err != nil {
+			return nil, page, newErrMDR(err)
+		}
+	}
+	return rows, page, new(MDR).SetCount(int64(len(rows)))
+}
+
+// Aggregate runs pipeline and decodes every output document into results,
+// a pointer to a slice. See the generic Aggregate for typed results.
+func (d *BaseMongoDAO[T]) Aggregate(ctx context.Context, pipeline any, results any) *MDR {
+	cur, err := d.Col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	defer cur.Close(ctx)
+
+	if err := cur.All(ctx, results); err != nil {
+		return newErrMDR(err)
+	}
+	return new(MDR).SetCount(int64(reflect.ValueOf(results).Elem().Len()))
+}
+
 func (d *BaseMongoDAO[T]) CreateIndexes(ctx context.Context, models []mongo.IndexModel) *MDR {
 	_, err := d.Col.Indexes().CreateMany(ctx, models)
 	return newErrMDR(err)
@@ -404,3 +1095,11 @@ func (d *BaseMongoDAO[T]) CreateIndexes(ctx context.Context, models []mongo.Inde
 func (d *BaseMongoDAO[T]) Count(ctx context.Context, filter any) (int64, error) {
 	return d.Col.CountDocuments(ctx, filter)
 }
+
+func (d *BaseMongoDAO[T]) Delete(ctx context.Context, filter any) *MDR {
+	r, err := d.Col.DeleteMany(ctx, filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return new(MDR).SetCount(r.DeletedCount)
+}
@@ -0,0 +1,147 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// spyDAO records the last write of a repository, the methods a test does not
+// need are left to the nil BaseDAO.
+type spyDAO[T any] struct {
+	BaseDAO[T]
+	filter any
+	set    any
+}
+
+func (d *spyDAO[T]) Update(ctx context.Context, filter any, model any) *MDR {
+	d.filter, d.set = filter, model
+	return new(MDR).SetCount(1)
+}
+
+func (d *spyDAO[T]) UpdateMany(ctx context.Context, filter any, model any) *MDR {
+	return d.Update(ctx, filter, model)
+}
+
+func (d *spyDAO[T]) Delete(ctx context.Context, filter any) *MDR {
+	d.filter, d.set = filter, nil
+	return new(MDR).SetCount(1)
+}
+
+func TestRepositorySoftDelete(t *testing.T) {
+	ctx := context.Background()
+	dao := &spyDAO[BaseModel]{}
+	repo := NewBaseRepository[BaseModel, BaseModel](dao, nil)
+
+	tests := []struct {
+		name   string
+		call   func() *MDR
+		filter bson.M
+		set    any
+	}{
+		{"Restore", func() *MDR { return repo.Restore(ctx, Where("_id").Eq("a")) },
+			bson.M{"_id": "a", "deleted_at": bson.M{"$ne": nil}}, bson.M{"deleted_at": nil}},
+		{"Restore with deleted", func() *MDR { return repo.Restore(ctx, Where("_id").Eq("a").WithDeleted()) },
+			bson.M{"_id": "a"}, bson.M{"deleted_at": nil}},
+		{"HardRemove", func() *MDR { return repo.HardRemove(ctx, Where("_id").Eq("a")) },
+			bson.M{"_id": "a", "deleted_at": nil}, nil},
+		{"HardRemove only deleted", func() *MDR { return repo.HardRemove(ctx, Where("_id").Eq("a").OnlyDeleted()) },
+			bson.M{"_id": "a", "deleted_at": bson.M{"$ne": nil}}, nil},
+	}
+	for _, tt := range tests {
+		if dr := tt.call(); dr.Error != nil {
+			t.Fatalf("%s() = %v", tt.name, dr.Error)
+		}
+		if !reflect.DeepEqual(dao.filter, tt.filter) || !reflect.DeepEqual(dao.set, tt.set) {
+			t.Errorf("%s() filter = %v, set %v", tt.name, dao.filter, dao.set)
+		}
+	}
+
+	before := time.Now().Add(-time.Hour)
+	repo.Purge(ctx, time.Hour)
+	lt, _ := dao.filter.(bson.M)["deleted_at"].(bson.M)["$lt"].(time.Time)
+	if lt.Before(before) || lt.After(time.Now().Add(-time.Hour)) {
+		t.Errorf("Purge() filter = %v", dao.filter)
+	}
+
+	if dr := repo.Restore(ctx, Criteria("a = ?")); dr.Error == nil {
+		t.Error("a criteria error must fail Restore")
+	}
+}
+
+type repoUserModel struct {
+	BaseModel `bson:",inline"`
+	Name      string `bson:"name"`
+	Role      string `bson:"role"`
+}
+
+type repoUser struct {
+	ID      HID
+	Name    string
+	Role    string
+	Version int64
+}
+
+func TestRepositoryVersion(t *testing.T) {
+	ctx := context.Background()
+	dao := &testDAO[repoUserModel]{}
+	repo := NewBaseRepository[repoUserModel, repoUser](dao, nil)
+
+	dr := repo.Save(ctx, repoUser{Name: "ann"})
+	if dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	id := dr.ID()
+
+	ann, _ := repo.FindOne(ctx, Where("_id").Eq(id))
+	ann.Role = "admin"
+	if dr := repo.Save(ctx, ann); dr.Error != nil {
+		t.Fatalf("update: %v", dr.Error)
+	}
+	if m, _ := dao.FindOne(ctx, bson.M{"_id": id}); m.Version != 2 || m.Role != "admin" {
+		t.Errorf("stored version = %d, role %s", m.Version, m.Role)
+	}
+
+	// ann still carries version 1
+	ann.Role = "root"
+	var e Error
+	if dr := repo.Save(ctx, ann); !errors.As(dr.Error, &e) || e.Code != ErrVersionConflict {
+		t.Errorf("stale update = %v", dr.Error)
+	}
+	if m, _ := dao.FindOne(ctx, bson.M{"_id": id}); m.Version != 2 || m.Role != "admin" {
+		t.Errorf("stale update changed the model: version %d, role %s", m.Version, m.Role)
+	}
+}
+
+func TestRepositoryRestore(t *testing.T) {
+	ctx := context.Background()
+	for _, history := range []bool{false, true} {
+		dao := NewMemoryDAO[memoryUserModel]()
+		repo := NewBaseRepository[memoryUserModel, memoryUser](dao, nil)
+		if history {
+			repo.WithHistory(NewHistory(NewMemoryDAO[HistoryRecord](), nil))
+		}
+		for _, name := range []string{"ann", "bob", "cat"} {
+			repo.Save(ctx, memoryUser{Name: name, Role: "user"})
+		}
+
+		repo.HardRemove(ctx, Where("name").Eq("cat"))
+		for _, name := range []string{"ann", "bob"} {
+			repo.Remove(ctx, Where("name").Eq(name))
+		}
+		if n := repo.Count(ctx, CriteriaBuilder{}); n != 0 {
+			t.Fatalf("history %v: Count() after Remove = %d", history, n)
+		}
+
+		// like HardRemove, Restore applies to every matching document
+		if dr := repo.Restore(ctx, Where("role").Eq("user")); dr.Error != nil || dr.Count != 2 {
+			t.Errorf("history %v: Restore() = %+v", history, dr)
+		}
+		if n := repo.Count(ctx, CriteriaBuilder{}); n != 2 {
+			t.Errorf("history %v: Count() after Restore = %d", history, n)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"reflect"
+	"sync"
+)
+
+// DomainEvent is something that happened to an aggregate.
+type DomainEvent interface {
+	EventName() string
+}
+
+// AggregateRoot records domain events on an entity, embed it and call Record
+// from the behaviour methods. BaseRepo.Save dispatches the recorded events
+// after the entity was written and clears them. Entities passed to Save by
+// value are cleared on the copy only, so use pointer entities or ClearEvents
+// when the same value is saved again.
+type AggregateRoot struct {
+	events []DomainEvent
+}
+
+func (a *AggregateRoot) Record(events ...DomainEvent) {
+	a.events = append(a.events, events...)
+}
+
+func (a *AggregateRoot) Events() []DomainEvent {
+	return a.events
+}
+
+func (a *AggregateRoot) ClearEvents() {
+	a.events = nil
+}
+
+type eventSource interface {
+	Events() []DomainEvent
+	ClearEvents()
+}
+
+// EventErrorPolicy decides what EventBus.Publish does with handler errors.
+type EventErrorPolicy int
+
+const (
+	// EventStopOnError stops at the first failing handler and returns its error.
+	EventStopOnError EventErrorPolicy = iota
+	// EventContinueOnError runs every handler and returns the joined errors.
+	EventContinueOnError
+	// EventLogOnError logs failing handlers and never fails the publisher.
+	EventLogOnError
+)
+
+type EventHandler func(ctx context.Context, event DomainEvent) error
+
+// EventBus dispatches domain events in process and synchronously, a handler
+// called with a transaction context takes part in the transaction and its
+// error aborts it under EventStopOnError and EventContinueOnError.
+type EventBus struct {
+	Logger *Logger
+	Policy EventErrorPolicy
+
+	mux      sync.RWMutex
+	handlers map[reflect.Type][]EventHandler
+}
+
+func NewEventBus(logger *Logger) *EventBus {
+	return &EventBus{
+		Logger:   logger,
+		handlers: map[reflect.Type][]EventHandler{},
+	}
+}
+
+func (b *EventBus) WithPolicy(policy EventErrorPolicy) *EventBus {
+	b.Policy = policy
+	return b
+}
+
+// Subscribe registers a handler for the events of type T, handlers run in the
+// order they were registered.
+func Subscribe[T DomainEvent](bus *EventBus, handler func(ctx context.Context, event T) error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	bus.mux.Lock()
+	defer bus.mux.Unlock()
+
+	if bus.handlers == nil {
+		bus.handlers = map[reflect.Type][]EventHandler{}
+	}
+	bus.handlers[t] = append(bus.handlers[t], func(ctx context.Context, event DomainEvent) error {
+		return handler(ctx, event.(T))
+	})
+}
+
+func (b *EventBus) Publish(ctx context.Context, events ...DomainEvent) error {
+	var errs []error
+
+	for _, event := range events {
+		b.mux.RLock()
+		handlers := b.handlers[reflect.TypeOf(event)]
+		b.mux.RUnlock()
+
+		for _, h := range handlers {
+			err := h(ctx, event)
+			if err == nil {
+				continue
+			}
+
+			switch b.Policy {
+			case EventStopOnError:
+				return err
+			case EventContinueOnError:
+				errs = append(errs, err)
+			default:
+				if b.Logger != nil {
+					b.Logger.Error("eventBus.Publish", zap.String("event", event.EventName()), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// eventSourceOf returns the event recorder of an entity, which is either a
+// pointer or a value embedding AggregateRoot.
+func eventSourceOf[E any](entity *E) eventSource {
+	if src, ok := any(*entity).(eventSource); ok {
+		return src
+	}
+	if src, ok := any(entity).(eventSource); ok {
+		return src
+	}
+	return nil
+}
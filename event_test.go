@@ -0,0 +1,152 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type userRenamed struct {
+	Name string
+}
+
+func (userRenamed) EventName() string { return "user.renamed" }
+
+type userDeleted struct{}
+
+func (userDeleted) EventName() string { return "user.deleted" }
+
+type eventUser struct {
+	AggregateRoot
+	ID   HID
+	Name string
+}
+
+func (u *eventUser) Rename(name string) {
+	u.Name = name
+	u.Record(userRenamed{Name: name})
+}
+
+func TestEventBus(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus(nil)
+
+	var got []string
+	Subscribe(bus, func(ctx context.Context, e userRenamed) error {
+		got = append(got, "renamed:"+e.Name)
+		return nil
+	})
+	Subscribe(bus, func(ctx context.Context, e userDeleted) error {
+		got = append(got, "deleted")
+		return errors.New("boom")
+	})
+	Subscribe(bus, func(ctx context.Context, e userDeleted) error {
+		got = append(got, "deleted again")
+		return nil
+	})
+
+	u := &eventUser{}
+	u.Rename("ann")
+	src := eventSourceOf(&u)
+	events := src.Events()
+	src.ClearEvents()
+	if len(events) != 1 || len(u.Events()) != 0 {
+		t.Fatalf("Events() = %v, left %v", events, u.Events())
+	}
+
+	if err := bus.Publish(ctx, events...); err != nil || len(got) != 1 || got[0] != "renamed:ann" {
+		t.Fatalf("Publish() = %v, handled %v", err, got)
+	}
+
+	got = nil
+	if err := bus.Publish(ctx, userDeleted{}); err == nil || len(got) != 1 {
+		t.Errorf("stop on error: Publish() = %v, handled %v", err, got)
+	}
+
+	got = nil
+	bus.WithPolicy(EventContinueOnError)
+	if err := bus.Publish(ctx, userDeleted{}); err == nil || len(got) != 2 {
+		t.Errorf("continue on error: Publish() = %v, handled %v", err, got)
+	}
+
+	got = nil
+	bus.WithPolicy(EventLogOnError)
+	if err := bus.Publish(ctx, userDeleted{}); err != nil || len(got) != 2 {
+		t.Errorf("log on error: Publish() = %v, handled %v", err, got)
+	}
+
+	v := eventUser{}
+	v.Rename("bob")
+	if src := eventSourceOf(&v); src == nil || len(src.Events()) != 1 {
+		t.Errorf("eventSourceOf() on a value entity = %v", src)
+	}
+}
+
+type failingDAO[T any] struct {
+	*MemoryDAO[T]
+	err error
+}
+
+func (d *failingDAO[T]) Insert(ctx context.Context, model T) *MDR {
+	if d.err != nil {
+		return newErrMDR(d.err)
+	}
+	return d.MemoryDAO.Insert(ctx, model)
+}
+
+func TestRepositoryEvents(t *testing.T) {
+	t.Run("pointer", func(t *testing.T) {
+		testRepositoryEvents(t, func(name string) *eventUser {
+			u := &eventUser{}
+			u.Rename(name)
+			return u
+		}, func(u *eventUser) int { return len(u.Events()) }, true)
+	})
+	// a value entity is saved as a copy, its caller keeps the events
+	t.Run("value", func(t *testing.T) {
+		testRepositoryEvents(t, func(name string) eventUser {
+			u := eventUser{}
+			u.Rename(name)
+			return u
+		}, func(u eventUser) int { return len(u.Events()) }, false)
+	})
+}
+
+func testRepositoryEvents[E any](t *testing.T, newUser func(name string) E, pending func(E) int, cleared bool) {
+	ctx := context.Background()
+	dao := &failingDAO[memoryUserModel]{MemoryDAO: NewMemoryDAO[memoryUserModel]()}
+	bus := NewEventBus(nil)
+	repo := NewBaseRepository[memoryUserModel, E](dao, nil).WithEventBus(bus)
+
+	var got []string
+	var handlerErr error
+	Subscribe(bus, func(ctx context.Context, e userRenamed) error {
+		if handlerErr != nil {
+			return handlerErr
+		}
+		got = append(got, e.Name)
+		return nil
+	})
+
+	u := newUser("ann")
+	if dr := repo.Save(ctx, u); dr.Error != nil || len(got) != 1 || got[0] != "ann" {
+		t.Fatalf("Save() = %v, handled %v", dr.Error, got)
+	}
+	if n := pending(u); cleared && n != 0 || !cleared && n != 1 {
+		t.Errorf("after Save() %d events left", n)
+	}
+
+	got = nil
+	dao.err = errors.New("write failed")
+	u = newUser("bob")
+	if dr := repo.Save(ctx, u); !errors.Is(dr.Error, dao.err) || len(got) != 0 || pending(u) != 1 {
+		t.Errorf("dao error: Save() = %v, handled %v, %d events left", dr.Error, got, pending(u))
+	}
+	dao.err = nil
+
+	handlerErr = errors.New("boom")
+	u = newUser("cat")
+	if dr := repo.Save(ctx, u); !errors.Is(dr.Error, handlerErr) || pending(u) != 1 {
+		t.Errorf("handler error: Save() = %v, %d events left", dr.Error, pending(u))
+	}
+}
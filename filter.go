@@ -0,0 +1,245 @@
+package hin
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	FilterQueryKey = "filter"
+	SortQueryKey   = "sort"
+)
+
+// filterOps lists the operators of the query string filter DSL, two character
+// operators first so that ">=" is not read as ">".
+var filterOps = []string{">=", "<=", "!=", "!~", "=", ">", "<", "~"}
+
+type filterField struct {
+	typ      reflect.Type
+	sortable bool
+}
+
+// BindFilter turns the query string filter DSL into criteria, e.g.
+//
+//	?filter=age>=18,name~foo,status=1|2&sort=-created_at
+//
+// Conditions are separated by "," and joined with AND, "|" separates the
+// values of "=" and "!=" into IN and NOT IN, "null" matches null values, and
+// "\" escapes a literal "," or "|". Only the fields declared on dto with a
+// `filter:"name"` tag may be used, `filter:"name,sort"` also allows sorting.
+// Values are converted to the Go type of the declared field.
+func BindFilter(ctx *gin.Context, dto any) (CriteriaBuilder, error) {
+	c, err := ParseFilter(ctx.Query(FilterQueryKey), ctx.Query(SortQueryKey), dto)
+	if err != nil {
+		Result.Fail(ctx, ErrParameterError, Result.WithMessage(err.Error()))
+	}
+	return c, err
+}
+
+func ParseFilter(filter string, sort string, dto any) (CriteriaBuilder, error) {
+	fields, err := filterFields(dto)
+	if err != nil {
+		return CriteriaBuilder{}, err
+	}
+
+	builder := CriteriaBuilder{}
+	for _, cond := range splitEscaped(filter, ',') {
+		if strings.TrimSpace(cond) == "" {
+			continue
+		}
+
+		c, err := parseFilterCondition(cond, fields)
+		if err != nil {
+			return CriteriaBuilder{}, err
+		}
+		builder = builder.And(c)
+	}
+
+	for _, s := range parseSortFields(strings.Split(sort, ",")...) {
+		if f, ok := fields[s.Field]; !ok || !f.sortable {
+			return CriteriaBuilder{}, fmt.Errorf("filter: sorting by %s is not allowed", s.Field)
+		}
+		builder.Orders = append(builder.Orders, s)
+	}
+
+	return builder, nil
+}
+
+func parseFilterCondition(cond string, fields map[string]filterField) (CriteriaBuilder, error) {
+	name, op, raw := "", "", ""
+	for i := range cond {
+		for _, o := range filterOps {
+			if strings.HasPrefix(cond[i:], o) {
+				name, op, raw = strings.TrimSpace(cond[:i]), o, cond[i+len(o):]
+				break
+			}
+		}
+		if op != "" {
+			break
+		}
+	}
+	if op == "" {
+		return CriteriaBuilder{}, fmt.Errorf("filter: missing operator in %q", cond)
+	}
+
+	f, ok := fields[name]
+	if !ok {
+		return CriteriaBuilder{}, fmt.Errorf("filter: field %q is not allowed", name)
+	}
+
+	w := Where(name)
+	raws := splitEscaped(raw, '|')
+
+	if (op == "=" || op == "!=") && len(raws) == 1 && raws[0] == "null" {
+		if op == "=" {
+			return w.IsNull(), nil
+		}
+		return w.NotNull(), nil
+	}
+
+	if op == "~" || op == "!~" {
+		c := w.Like(unescapeFilter(raw))
+		if op == "!~" {
+			c = c.Not()
+		}
+		return c, nil
+	}
+
+	values := make([]any, 0, len(raws))
+	for _, r := range raws {
+		v, err := convertFilterValue(unescapeFilter(r), f.typ)
+		if err != nil {
+			return CriteriaBuilder{}, fmt.Errorf("filter: invalid value %q of %s: %w", r, name, err)
+		}
+		values = append(values, v)
+	}
+
+	if len(values) > 1 {
+		switch op {
+		case "=":
+			return w.In(values...), nil
+		case "!=":
+			return w.Nin(values...), nil
+		}
+		return CriteriaBuilder{}, fmt.Errorf("filter: multiple values are not allowed with %s", op)
+	}
+
+	switch op {
+	case "=":
+		return w.Eq(values[0]), nil
+	case "!=":
+		return w.Ne(values[0]), nil
+	case ">":
+		return w.Gt(values[0]), nil
+	case ">=":
+		return w.Gte(values[0]), nil
+	case "<":
+		return w.Lt(values[0]), nil
+	}
+	return w.Lte(values[0]), nil
+}
+
+func filterFields(dto any) (map[string]filterField, error) {
+	t := reflect.TypeOf(dto)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("filter: unsupported dto type %T", dto)
+	}
+
+	fields := map[string]filterField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("filter")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if name == "" {
+			name = toSnake(field.Name)
+		}
+
+		ff := filterField{typ: field.Type}
+		for _, o := range opts[1:] {
+			if o == "sort" {
+				ff.sortable = true
+			}
+		}
+		fields[name] = ff
+	}
+	return fields, nil
+}
+
+func convertFilterValue(s string, t reflect.Type) (any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+
+	var v any
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		v = s
+	case reflect.Bool:
+		v, err = strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, t.Bits())
+		v = reflect.ValueOf(i).Convert(t).Interface()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, t.Bits())
+		v = reflect.ValueOf(u).Convert(t).Interface()
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(s, t.Bits())
+		v = reflect.ValueOf(f).Convert(t).Interface()
+	default:
+		return nil, fmt.Errorf("unsupported field type %s", t)
+	}
+	return v, err
+}
+
+// splitEscaped splits s by sep, keeping separators escaped with "\".
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeFilter(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -32,6 +32,20 @@ func (h *HID) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// GobEncode implements gob.GobEncoder, the id has no exported field.
+func (h HID) GobEncode() ([]byte, error) {
+	return h.id.Bytes(), nil
+}
+
+func (h *HID) GobDecode(b []byte) error {
+	id, err := xid.FromBytes(b)
+	if err != nil {
+		return err
+	}
+	*h = HID{id: id}
+	return nil
+}
+
 func NewID() HID {
 	return HID{
 		id: xid.New(),
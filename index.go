@@ -0,0 +1,260 @@
+package hin
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// IndexesOf builds the indexes declared by the index tags of the model T.
+//
+//	Email    string    `bson:"email" index:"unique,live"`
+//	TenantID string    `bson:"tenant_id" index:"tenant_name,unique"`
+//	Name     string    `bson:"name" index:"tenant_name,desc"`
+//	ExpireAt time.Time `bson:"expire_at" index:"ttl=0s"`
+//
+// The first item of a tag that is not an option names a compound index, the
+// fields of a group are keyed in the order they are declared and the options
+// of any of them apply to the index. The options are unique, desc, ttl=<go
+// duration> and live, which keeps a unique index to documents that are not
+// soft deleted. Mongo partial indexes cannot select documents without a
+// field, so live appends deleted_at to the key instead: the deletion time
+// sets removed documents apart while every live document has none.
+func IndexesOf[T any]() ([]mongo.IndexModel, error) {
+	var m T
+	var specs []*indexSpec
+	if err := indexFields(reflect.TypeOf(m), &specs); err != nil {
+		return nil, err
+	}
+
+	models := make([]mongo.IndexModel, 0, len(specs))
+	for _, s := range specs {
+		if s.ttl != nil && len(s.keys) > 1 {
+			return nil, fmt.Errorf("index %s: ttl needs a single field", s.name)
+		}
+
+		keys := s.keys
+		if s.live && s.unique && !lo.ContainsBy(keys, func(e bson.E) bool { return e.Key == "deleted_at" }) {
+			keys = append(keys, bson.E{Key: "deleted_at", Value: 1})
+		}
+		opts := mopt.Index().SetName(s.name)
+		if s.unique {
+			opts.SetUnique(true)
+		}
+		if s.ttl != nil {
+			opts.SetExpireAfterSeconds(int32(s.ttl.Seconds()))
+		}
+		models = append(models, mongo.IndexModel{Keys: keys, Options: opts})
+	}
+	return models, nil
+}
+
+type indexSpec struct {
+	name   string
+	keys   bson.D
+	unique bool
+	live   bool
+	ttl    *time.Duration
+}
+
+func indexFields(t reflect.Type, specs *[]*indexSpec) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		opts := strings.Split(f.Tag.Get("bson"), ",")
+		if !f.IsExported() || opts[0] == "-" {
+			continue
+		}
+		if lo.Contains(opts[1:], "inline") {
+			if err := indexFields(f.Type, specs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tag, ok := f.Tag.Lookup("index")
+		if !ok {
+			continue
+		}
+		field := opts[0]
+		if field == "" {
+			field = strings.ToLower(f.Name)
+		}
+		if err := addIndexField(specs, field, tag); err != nil {
+			return fmt.Errorf("index tag of %s.%s: %w", t.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+func addIndexField(specs *[]*indexSpec, field string, tag string) error {
+	var group string
+	dir := 1
+	var unique, live bool
+	var ttl *time.Duration
+	for i, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "unique":
+			unique = true
+		case item == "live":
+			live = true
+		case item == "desc":
+			dir = -1
+		case strings.HasPrefix(item, "ttl="):
+			d, err := time.ParseDuration(strings.TrimPrefix(item, "ttl="))
+			if err != nil {
+				return err
+			}
+			ttl = &d
+		case i == 0:
+			group = item
+		default:
+			return fmt.Errorf("unknown option %q", item)
+		}
+	}
+
+	name := group
+	if name == "" {
+		name = fmt.Sprintf("%s_%d", field, dir)
+	}
+	spec, ok := lo.Find(*specs, func(s *indexSpec) bool { return s.name == name })
+	if !ok || group == "" {
+		spec = &indexSpec{name: name}
+		*specs = append(*specs, spec)
+	}
+	spec.keys = append(spec.keys, bson.E{Key: field, Value: dir})
+	spec.unique = spec.unique || unique
+	spec.live = spec.live || live
+	if ttl != nil {
+		spec.ttl = ttl
+	}
+	return nil
+}
+
+// IndexReport lists the changes of EnsureIndexes by index name. Stale indexes
+// exist on the collection but are not declared, or are declared differently.
+type IndexReport struct {
+	Created []string
+	Stale   []string
+	Dropped []string
+}
+
+type existingIndex struct {
+	Name               string   `bson:"name"`
+	Key                bson.D   `bson:"key"`
+	Unique             bool     `bson:"unique"`
+	ExpireAfterSeconds *float64 `bson:"expireAfterSeconds"`
+}
+
+// EnsureIndexes creates the indexes declared by the index tags of T that the
+// collection misses. Stale indexes are reported, and with drop they are
+// dropped, and recreated when they are declared differently.
+func (d *BaseMongoDAO[T]) EnsureIndexes(ctx context.Context, drop bool) (IndexReport, error) {
+	var report IndexReport
+	declared, err := IndexesOf[T]()
+	if err != nil {
+		return report, err
+	}
+
+	cur, err := d.Col.Indexes().List(ctx)
+	if err != nil {
+		return report, err
+	}
+	var existing []existingIndex
+	if err := cur.All(ctx, &existing); err != nil {
+		return report, err
+	}
+
+	create, stale := diffIndexes(declared, existing)
+	report.Stale = stale
+	if drop {
+		for _, name := range stale {
+			if _, err := d.Col.Indexes().DropOne(ctx, name); err != nil {
+				return report, err
+			}
+			report.Dropped = append(report.Dropped, name)
+		}
+		// declared indexes dropped for a different spec are created again
+		for _, m := range declared {
+			if lo.Contains(stale, *m.Options.Name) {
+				create = append(create, m)
+			}
+		}
+	}
+
+	if len(create) > 0 {
+		if _, err := d.Col.Indexes().CreateMany(ctx, create); err != nil {
+			return report, err
+		}
+		for _, m := range create {
+			report.Created = append(report.Created, *m.Options.Name)
+		}
+	}
+	if len(report.Stale) > 0 && !drop {
+		d.Logger.Warn("EnsureIndexes: stale indexes", zap.String("collection", d.Col.Name()), zap.Strings("indexes", report.Stale))
+	}
+	return report, nil
+}
+
+// diffIndexes returns the declared indexes missing from existing and the
+// names of the existing ones that are not declared or differ.
+func diffIndexes(declared []mongo.IndexModel, existing []existingIndex) ([]mongo.IndexModel, []string) {
+	var create []mongo.IndexModel
+	var stale []string
+	for _, m := range declared {
+		e, ok := lo.Find(existing, func(e existingIndex) bool { return e.Name == *m.Options.Name })
+		if !ok {
+			create = append(create, m)
+		} else if !sameIndex(m, e) {
+			stale = append(stale, e.Name)
+		}
+	}
+	for _, e := range existing {
+		if e.Name == "_id_" {
+			continue
+		}
+		if !lo.ContainsBy(declared, func(m mongo.IndexModel) bool { return *m.Options.Name == e.Name }) {
+			stale = append(stale, e.Name)
+		}
+	}
+	return create, stale
+}
+
+func sameIndex(m mongo.IndexModel, e existingIndex) bool {
+	keys := m.Keys.(bson.D)
+	if len(keys) != len(e.Key) {
+		return false
+	}
+	for i, k := range keys {
+		a, _ := toFloat(k.Value)
+		b, _ := toFloat(e.Key[i].Value)
+		if k.Key != e.Key[i].Key || a != b {
+			return false
+		}
+	}
+
+	unique := m.Options.Unique != nil && *m.Options.Unique
+	if unique != e.Unique {
+		return false
+	}
+	if ttl := m.Options.ExpireAfterSeconds; (ttl == nil) != (e.ExpireAfterSeconds == nil) ||
+		(ttl != nil && float64(*ttl) != *e.ExpireAfterSeconds) {
+		return false
+	}
+	return true
+}
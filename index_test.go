@@ -0,0 +1,78 @@
+package hin
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type indexModel struct {
+	BaseModel   `bson:",inline"`
+	TenantModel `bson:",inline"`
+	Email       string    `bson:"email" index:"unique,live"`
+	Name        string    `bson:"name" index:"tenant_name,desc"`
+	Code        string    `bson:"code" index:"tenant_name,unique"`
+	ExpireAt    time.Time `bson:"expire_at" index:"ttl=24h"`
+	Age         int       `bson:"age" index:""`
+}
+
+func TestIndexesOf(t *testing.T) {
+	models, err := IndexesOf[indexModel]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name   string
+		keys   bson.D
+		unique bool
+		ttl    int32
+	}{
+		{"email_1", bson.D{{Key: "email", Value: 1}, {Key: "deleted_at", Value: 1}}, true, 0},
+		{"tenant_name", bson.D{{Key: "name", Value: -1}, {Key: "code", Value: 1}}, true, 0},
+		{"expire_at_1", bson.D{{Key: "expire_at", Value: 1}}, false, 86400},
+		{"age_1", bson.D{{Key: "age", Value: 1}}, false, 0},
+	}
+	if len(models) != len(want) {
+		t.Fatalf("IndexesOf() = %d indexes", len(models))
+	}
+	for i, w := range want {
+		m := models[i]
+		if *m.Options.Name != w.name || !reflect.DeepEqual(m.Keys, w.keys) ||
+			(m.Options.Unique != nil) != w.unique ||
+			(w.ttl != 0) != (m.Options.ExpireAfterSeconds != nil) {
+			t.Errorf("index %d = %s %v", i, *m.Options.Name, m.Keys)
+		}
+		if w.ttl != 0 && *m.Options.ExpireAfterSeconds != w.ttl {
+			t.Errorf("ttl of %s = %d", w.name, *m.Options.ExpireAfterSeconds)
+		}
+	}
+
+	type bad struct {
+		Name string `bson:"name" index:"a,b"`
+	}
+	if _, err := IndexesOf[bad](); err == nil {
+		t.Error("unknown option must fail")
+	}
+}
+
+func TestDiffIndexes(t *testing.T) {
+	declared, _ := IndexesOf[indexModel]()
+	ttl := float64(3600)
+	existing := []existingIndex{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "email_1", Key: bson.D{{Key: "email", Value: int32(1)}, {Key: "deleted_at", Value: int32(1)}}, Unique: true},
+		{Name: "tenant_name", Key: bson.D{{Key: "name", Value: int32(-1)}, {Key: "code", Value: int32(1)}}},
+		{Name: "expire_at_1", Key: bson.D{{Key: "expire_at", Value: int32(1)}}, ExpireAfterSeconds: &ttl},
+		{Name: "legacy_1", Key: bson.D{{Key: "legacy", Value: int32(1)}}},
+	}
+
+	create, stale := diffIndexes(declared, existing)
+	if len(create) != 1 || *create[0].Options.Name != "age_1" {
+		t.Errorf("create = %v", create)
+	}
+	if !reflect.DeepEqual(stale, []string{"tenant_name", "expire_at_1", "legacy_1"}) {
+		t.Errorf("stale = %v", stale)
+	}
+}
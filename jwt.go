@@ -9,6 +9,7 @@ import (
 
 type JwtClaims struct {
 	Username string
+	TenantID string `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -56,6 +57,12 @@ func (jwtOptions) WithUsername(username string) jwtOption {
 	}
 }
 
+func (jwtOptions) WithTenant(tenant string) jwtOption {
+	return func(claims *JwtClaims) {
+		claims.TenantID = tenant
+	}
+}
+
 func (jwtOptions) WithClaims(c *JwtClaims) jwtOption {
 	return func(claims *JwtClaims) {
 		_ = copier.Copy(claims, c)
@@ -0,0 +1,369 @@
+package hin
+
+import (
+	"bytes"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Match reports whether v, a struct or a map, is selected by the criteria.
+// v is encoded as the bson document Mongo would store and tested against
+// Mgo(), so the in-memory result agrees with the query, soft delete scope
+// included. A criteria with an error matches nothing.
+func (c CriteriaBuilder) Match(v any) bool {
+	filter := c.Mgo()
+	if filter == nil {
+		return false
+	}
+
+	ok, err := mgoMatch(v, filter)
+	return err == nil && ok
+}
+
+// mgoMatch evaluates a Mongo filter document against a document in memory.
+func mgoMatch(doc any, filter any) (bool, error) {
+	d, err := toBsonM(doc)
+	if err != nil {
+		return false, err
+	}
+	f, err := toBsonM(filter)
+	if err != nil {
+		return false, err
+	}
+	return matchDoc(d, f)
+}
+
+// toBsonM normalizes v through a bson round trip, so that numbers, times,
+// slices and nested documents have the types decoded from the database.
+func toBsonM(v any) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{}
+	return m, bson.Unmarshal(b, &m)
+}
+
+func matchDoc(doc bson.M, filter bson.M) (bool, error) {
+	for k, cond := range filter {
+		var ok bool
+		var err error
+
+		switch k {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, k, cond)
+		default:
+			if strings.HasPrefix(k, "$") {
+				return false, fmt.Errorf("match: unsupported operator %s", k)
+			}
+			ok, err = matchField(doc, k, cond)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond any) (bool, error) {
+	clauses, ok := cond.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("match: %s requires an array", op)
+	}
+
+	for _, c := range clauses {
+		sub, ok := c.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("match: %s requires an array of documents", op)
+		}
+
+		matched, err := matchDoc(doc, sub)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchField(doc bson.M, key string, cond any) (bool, error) {
+	values, found := lookupPath(doc, strings.Split(key, "."))
+
+	ops, ok := cond.(bson.M)
+	if !ok || !isMgoOps(ops) {
+		return matchEq(values, found, cond), nil
+	}
+
+	for op, arg := range ops {
+		ok, err := matchOp(values, found, op, arg, ops)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchOp(values []any, found bool, op string, arg any, ops bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEq(values, found, arg), nil
+	case "$ne":
+		return !matchEq(values, found, arg), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchCompare(values, found, op, arg), nil
+	case "$in", "$nin":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("match: %s requires an array", op)
+		}
+		in := false
+		for _, v := range list {
+			if matchEq(values, found, v) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$exists":
+		return found == truthy(arg), nil
+	case "$regex":
+		options, _ := ops["$options"].(string)
+		return matchRegex(values, arg, options)
+	case "$options":
+		return true, nil
+	case "$not":
+		if sub, ok := arg.(bson.M); ok {
+			for o, a := range sub {
+				ok, err := matchOp(values, found, o, a, sub)
+				if err != nil || !ok {
+					return true, err
+				}
+			}
+			return false, nil
+		}
+		ok, err := matchRegex(values, arg, "")
+		return !ok, err
+	}
+	return false, fmt.Errorf("match: unsupported operator %s", op)
+}
+
+// lookupPath resolves a dotted path. Arrays on the way are traversed element
+// wise, numeric parts index into arrays.
+func lookupPath(v any, parts []string) ([]any, bool) {
+	if len(parts) == 0 {
+		return []any{v}, true
+	}
+
+	switch x := v.(type) {
+	case bson.M:
+		child, ok := x[parts[0]]
+		if !ok {
+			return nil, false
+		}
+		return lookupPath(child, parts[1:])
+	case bson.A:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i < 0 || i >= len(x) {
+				return nil, false
+			}
+			return lookupPath(x[i], parts[1:])
+		}
+
+		var out []any
+		found := false
+		for _, e := range x {
+			if vs, ok := lookupPath(e, parts); ok {
+				out = append(out, vs...)
+				found = true
+			}
+		}
+		return out, found
+	}
+	return nil, false
+}
+
+// candidates expands array values, a condition holds for an array when it
+// holds for the array itself or for one of its elements.
+func candidates(values []any) []any {
+	var out []any
+	for _, v := range values {
+		out = append(out, v)
+		if a, ok := v.(bson.A); ok {
+			out = append(out, a...)
+		}
+	}
+	return out
+}
+
+func matchEq(values []any, found bool, want any) bool {
+	if want == nil && !found {
+		return true
+	}
+	for _, v := range candidates(values) {
+		if valuesEqual(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCompare(values []any, found bool, op string, want any) bool {
+	if want == nil {
+		// only null compares to null
+		return (op == "$gte" || op == "$lte") && matchEq(values, found, nil)
+	}
+
+	for _, v := range candidates(values) {
+		c, ok := compareValues(v, want)
+		if !ok {
+			continue
+		}
+		switch {
+		case op == "$gt" && c > 0, op == "$gte" && c >= 0, op == "$lt" && c < 0, op == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegex(values []any, pattern any, options string) (bool, error) {
+	var expr string
+	switch p := pattern.(type) {
+	case string:
+		expr = p
+	case primitive.Regex:
+		expr, options = p.Pattern, p.Options+options
+	default:
+		return false, fmt.Errorf("match: invalid $regex %v", pattern)
+	}
+
+	var flags string
+	for _, o := range options {
+		if o == 'i' || o == 'm' || o == 's' {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range candidates(values) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func valuesEqual(a, b any) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two scalar values of the same bson type class, ok is
+// false when they are not comparable.
+func compareValues(a, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, a == nil && b == nil
+	}
+
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			}
+			return 1, true
+		}
+	case primitive.DateTime, time.Time:
+		switch b.(type) {
+		case primitive.DateTime, time.Time:
+			return toTime(a).Compare(toTime(b)), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toTime(v any) time.Time {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return t.Time()
+	case time.Time:
+		return t
+	}
+	return time.Time{}
+}
+
+func truthy(v any) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return v != nil
+}
@@ -0,0 +1,720 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryDAO is a BaseDAO keeping the documents in memory, for tests of
+// repositories and services without a MongoDB. Filters are evaluated like
+// Match, so the documents built by CriteriaBuilder.Mgo() select the same
+// rows as on the server. Indexes and transactions are ignored, _id is unique.
+// Aggregate supports $match, $group, $sort, $skip, $limit and $project with
+// field inclusion.
+type MemoryDAO[T any] struct {
+	mux  sync.RWMutex
+	docs []bson.M
+}
+
+func NewMemoryDAO[T any]() *MemoryDAO[T] {
+	return &MemoryDAO[T]{}
+}
+
+func (d *MemoryDAO[T]) Insert(ctx context.Context, model T) *MDR {
+	return d.InsertMany(ctx, []T{model})
+}
+
+func (d *MemoryDAO[T]) InsertMany(ctx context.Context, model []T) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	r := new(MDR)
+	for _, m := range model {
+		id, err := d.insert(m)
+		if err != nil {
+			r.Error = err
+			return r
+		}
+		r.IDs = append(r.IDs, id)
+		r.Count++
+	}
+	return r
+}
+
+func (d *MemoryDAO[T]) insert(model any) (string, error) {
+	doc, id, err := bulkInsertDoc(model)
+	if err != nil {
+		return "", err
+	}
+	if d.indexOf(id) >= 0 {
+		return "", fmt.Errorf("memory: duplicate key _id %s", id)
+	}
+
+	m := bson.M{}
+	for _, e := range doc {
+		m[e.Key] = e.Value
+	}
+	if m, err = toBsonM(m); err != nil {
+		return "", err
+	}
+	d.docs = append(d.docs, m)
+	return id, nil
+}
+
+func (d *MemoryDAO[T]) indexOf(id string) int {
+	for i, doc := range d.docs {
+		if bulkID(doc["_id"]) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *MemoryDAO[T]) Find(ctx context.Context, filter any, opts ...FindOption) ([]T, *MDR) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	docs, err := d.find(filter, newFindOptions(opts))
+	if err != nil {
+		return nil, newErrMDR(err)
+	}
+
+	r := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var m T
+		if err := decodeDoc(doc, &m); err != nil {
+			return nil, newErrMDR(err)
+		}
+		r = append(r, m)
+	}
+	return r, new(MDR).SetCount(int64(len(r)))
+}
+
+func (d *MemoryDAO[T]) find(filter any, o *FindOptions) ([]bson.M, error) {
+	docs, err := d.match(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sortDocs(docs, o.Sort)
+	if o.Skip > 0 {
+		docs = docs[min(o.Skip, int64(len(docs))):]
+	}
+	if o.Limit > 0 && int64(len(docs)) > o.Limit {
+		docs = docs[:o.Limit]
+	}
+	if len(o.Projection) > 0 {
+		for i, doc := range docs {
+			docs[i] = projectDoc(doc, o.Projection)
+		}
+	}
+	return docs, nil
+}
+
+func (d *MemoryDAO[T]) match(filter any) ([]bson.M, error) {
+	f, err := toBsonM(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.M
+	for _, doc := range d.docs {
+		ok, err := matchDoc(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (d *MemoryDAO[T]) FindOne(ctx context.Context, filter any, opts ...FindOption) (T, *MDR) {
+	var m T
+	r, dr := d.Find(ctx, filter, append(opts, WithLimit(1))...)
+	if dr.Error != nil {
+		return m, dr
+	}
+	if len(r) == 0 {
+		return m, newErrMDR(mongo.ErrNoDocuments)
+	}
+	return r[0], dr
+}
+
+func (d *MemoryDAO[T]) Each(ctx context.Context, filter any, fn func(T) error, opts ...FindOption) error {
+	r, dr := d.Find(ctx, filter, opts...)
+	if dr.Error != nil {
+		return dr.Error
+	}
+	for _, m := range r {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *MemoryDAO[T]) Iter(ctx context.Context, filter any, opts ...FindOption) func(yield func(T, error) bool) {
+	return iterOf(func(fn func(T) error) error {
+		return d.Each(ctx, filter, fn, opts...)
+	})
+}
+
+func (d *MemoryDAO[T]) Update(ctx context.Context, filter any, model any) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.update(filter, model, false, false)
+}
+
+func (d *MemoryDAO[T]) UpdateById(ctx context.Context, id any, model any) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.update(bson.M{"_id": id}, model, false, false).setID(id)
+}
+
+func (d *MemoryDAO[T]) UpdateMany(ctx context.Context, filter any, model any) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.update(filter, model, true, false)
+}
+
+// update applies model like $set, Count is the number of documents changed.
+func (d *MemoryDAO[T]) update(filter any, model any, many bool, upsert bool) *MDR {
+	set, err := toBsonM(model)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	docs, err := d.match(filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	if len(docs) == 0 && upsert {
+		f, err := toBsonM(filter)
+		if err != nil {
+			return newErrMDR(err)
+		}
+		doc := bson.M{}
+		for k, v := range f {
+			if ops, ok := v.(bson.M); !strings.HasPrefix(k, "$") && (!ok || !isMgoOps(ops)) {
+				setPath(doc, k, v)
+			}
+		}
+		for k, v := range set {
+			setPath(doc, k, v)
+		}
+		id, err := d.insert(doc)
+		if err != nil {
+			return newErrMDR(err)
+		}
+		return &MDR{Count: 1, Upserted: 1, IDs: []string{id}}
+	}
+
+	r := new(MDR)
+	for _, doc := range docs {
+		r.Matched++
+		changed := false
+		for k, v := range set {
+			if k == "_id" {
+				continue
+			}
+			if old, ok := lookupPath(doc, strings.Split(k, ".")); !ok || len(old) != 1 || !reflect.DeepEqual(old[0], v) {
+				setPath(doc, k, v)
+				changed = true
+			}
+		}
+		if changed {
+			r.Modified++
+		}
+		if !many {
+			break
+		}
+	}
+	r.Count = r.Modified
+	return r
+}
+
+func setPath(doc bson.M, path string, v any) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := doc[p].(bson.M)
+		if !ok {
+			next = bson.M{}
+			doc[p] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = v
+}
+
+func (d *MemoryDAO[T]) Delete(ctx context.Context, filter any) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.delete(filter, true)
+}
+
+func (d *MemoryDAO[T]) delete(filter any, many bool) *MDR {
+	f, err := toBsonM(filter)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	r := new(MDR)
+	kept := make([]bson.M, 0, len(d.docs))
+	for _, doc := range d.docs {
+		ok, err := matchDoc(doc, f)
+		if err != nil {
+			return newErrMDR(err)
+		}
+		if ok && (many || r.Count == 0) {
+			r.Count++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	d.docs = kept
+	r.Deleted = r.Count
+	return r
+}
+
+func (d *MemoryDAO[T]) Count(ctx context.Context, filter any) (int64, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	docs, err := d.match(filter)
+	return int64(len(docs)), err
+}
+
+func (d *MemoryDAO[T]) Paging(ctx context.Context, filter any, paging PagingQuery, opts ...FindOption) ([]T, int64, *MDR) {
+	opts = append(opts, WithLimit(paging.Count), WithSkip(paging.Count*paging.Page))
+	r, dr := d.Find(ctx, filter, opts...)
+	if dr.Error != nil {
+		return nil, 0, dr
+	}
+	total, err := d.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, newErrMDR(err)
+	}
+	return r, total, dr
+}
+
+func (d *MemoryDAO[T]) CursorPaging(ctx context.Context, filter any, cursor CursorQuery, opts ...FindOption) ([]T, CursorPage, *MDR) {
+	plan, err := newCursorPlan(filter, cursor, opts)
+	if err != nil {
+		return nil, CursorPage{}, newErrMDR(err)
+	}
+
+	rows, dr := d.Find(ctx, plan.filter, plan.FindOptions()...)
+	if dr.Error != nil {
+		return nil, CursorPage{}, dr
+	}
+
+	rows, page, err := cursorResult(plan, rows)
+	if err != nil {
+		return nil, page, newErrMDR(err)
+	}
+
+	if !cursor.SkipTotal {
+		if page.Total, err = d.Count(ctx, filter); err != nil {
+			return nil, page, newErrMDR(err)
+		}
+	}
+	return rows, page, new(MDR).SetCount(int64(len(rows)))
+}
+
+func (d *MemoryDAO[T]) BulkWrite(ctx context.Context, ops []BulkOp, ordered bool) *MDR {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	r := &MDR{Ops: make([]BulkOpResult, len(ops))}
+	for i, op := range ops {
+		res := BulkOpResult{Index: i}
+
+		var dr *MDR
+		switch op.Type {
+		case BulkInsert:
+			id, err := d.insert(op.Model)
+			dr = &MDR{Error: err, IDs: []string{id}}
+			if err == nil {
+				r.Inserted++
+				res.ID = id
+			}
+		case BulkUpdate, BulkUpsert:
+			dr = d.update(op.Filter, op.Model, op.Many, op.Type == BulkUpsert)
+			if dr.Upserted > 0 {
+				res.ID = dr.IDs[0]
+			}
+		case BulkDelete:
+			dr = d.delete(op.Filter, op.Many)
+		default:
+			dr = newErrMDR(fmt.Errorf("bulk: operation %d: unknown type %d", i, op.Type))
+		}
+
+		r.Matched += dr.Matched
+		r.Modified += dr.Modified
+		r.Upserted += dr.Upserted
+		r.Deleted += dr.Deleted
+		res.OK, res.Error = dr.Error == nil, dr.Error
+		r.Ops[i] = res
+
+		if dr.Error != nil {
+			r.Error = errors.Join(r.Error, fmt.Errorf("bulk: operation %d: %w", i, dr.Error))
+			if ordered {
+				for j := i + 1; j < len(ops); j++ {
+					r.Ops[j] = BulkOpResult{Index: j, Error: ErrBulkNotExecuted}
+				}
+				break
+			}
+		}
+	}
+
+	for _, op := range r.Ops {
+		if op.ID != "" {
+			r.IDs = append(r.IDs, op.ID)
+		}
+	}
+	r.Count = r.Inserted + r.Modified + r.Upserted + r.Deleted
+	return r
+}
+
+func (d *MemoryDAO[T]) CreateIndexes(ctx context.Context, models []mongo.IndexModel) *MDR {
+	return new(MDR)
+}
+
+// Aggregate runs the supported stages on copies of the documents.
+func (d *MemoryDAO[T]) Aggregate(ctx context.Context, pipeline any, results any) *MDR {
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return newErrMDR(errors.New("memory: results must be a pointer to a slice"))
+	}
+
+	stages, err := pipelineStages(pipeline)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	d.mux.RLock()
+	docs := make([]bson.M, 0, len(d.docs))
+	for _, doc := range d.docs {
+		c, err := toBsonM(doc)
+		if err != nil {
+			d.mux.RUnlock()
+			return newErrMDR(err)
+		}
+		docs = append(docs, c)
+	}
+	d.mux.RUnlock()
+
+	for _, stage := range stages {
+		if docs, err = runStage(docs, stage); err != nil {
+			return newErrMDR(err)
+		}
+	}
+
+	out := reflect.MakeSlice(rv.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		item := reflect.New(out.Type().Elem())
+		if err := decodeDoc(doc, item.Interface()); err != nil {
+			return newErrMDR(err)
+		}
+		out = reflect.Append(out, item.Elem())
+	}
+	rv.Elem().Set(out)
+	return new(MDR).SetCount(int64(len(docs)))
+}
+
+func pipelineStages(pipeline any) ([]bson.E, error) {
+	b, err := bson.Marshal(bson.M{"p": pipeline})
+	if err != nil {
+		return nil, err
+	}
+	var w struct {
+		P []bson.D `bson:"p"`
+	}
+	if err := bson.Unmarshal(b, &w); err != nil {
+		return nil, err
+	}
+
+	stages := make([]bson.E, 0, len(w.P))
+	for _, s := range w.P {
+		if len(s) != 1 {
+			return nil, errors.New("memory: a pipeline stage must have exactly one field")
+		}
+		stages = append(stages, s[0])
+	}
+	return stages, nil
+}
+
+func runStage(docs []bson.M, stage bson.E) ([]bson.M, error) {
+	switch stage.Key {
+	case "$match":
+		f, err := toBsonM(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		var out []bson.M
+		for _, doc := range docs {
+			ok, err := matchDoc(doc, f)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out = append(out, doc)
+			}
+		}
+		return out, nil
+	case "$sort":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("memory: $sort requires a document")
+		}
+		sorts := make([]SortField, 0, len(spec))
+		for _, e := range spec {
+			dir, _ := toFloat(e.Value)
+			sorts = append(sorts, SortField{Field: e.Key, Desc: dir < 0})
+		}
+		sortDocs(docs, sorts)
+		return docs, nil
+	case "$skip", "$limit":
+		n, ok := toFloat(stage.Value)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("memory: %s requires a number", stage.Key)
+		}
+		if stage.Key == "$skip" {
+			return docs[min(int(n), len(docs)):], nil
+		}
+		return docs[:min(int(n), len(docs))], nil
+	case "$project":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("memory: $project requires a document")
+		}
+		fields := make([]string, 0, len(spec))
+		for _, e := range spec {
+			if !truthy(e.Value) {
+				return nil, errors.New("memory: $project only supports including fields")
+			}
+			fields = append(fields, e.Key)
+		}
+		for i, doc := range docs {
+			docs[i] = projectDoc(doc, fields)
+		}
+		return docs, nil
+	case "$group":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, errors.New("memory: $group requires a document")
+		}
+		return groupDocs(docs, spec)
+	}
+	return nil, fmt.Errorf("memory: unsupported stage %s", stage.Key)
+}
+
+// groupDocs supports an _id of a field path, a constant or a document of
+// field paths, and the accumulators $sum, $avg, $min, $max, $first, $last,
+// $push and $addToSet.
+func groupDocs(docs []bson.M, spec bson.D) ([]bson.M, error) {
+	var id any
+	for _, e := range spec {
+		if e.Key == "_id" {
+			id = e.Value
+		}
+	}
+
+	var groups []bson.M
+	var sums, counts []map[string]float64
+	for _, doc := range docs {
+		key := evalExpr(doc, id)
+
+		gi := -1
+		for i, g := range groups {
+			if valuesEqual(g["_id"], key) || reflect.DeepEqual(g["_id"], key) {
+				gi = i
+				break
+			}
+		}
+		if gi < 0 {
+			groups = append(groups, bson.M{"_id": key})
+			sums = append(sums, map[string]float64{})
+			counts = append(counts, map[string]float64{})
+			gi = len(groups) - 1
+		}
+		g := groups[gi]
+
+		for _, e := range spec {
+			if e.Key == "_id" {
+				continue
+			}
+			acc, ok := e.Value.(bson.D)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("memory: invalid accumulator for %s", e.Key)
+			}
+			v := evalExpr(doc, acc[0].Value)
+			cur, seen := g[e.Key]
+
+			switch acc[0].Key {
+			case "$sum":
+				f, _ := toFloat(v)
+				s, _ := toFloat(cur)
+				if isInt(v) && (!seen || isInt(cur)) {
+					g[e.Key] = int64(s) + int64(f)
+				} else {
+					g[e.Key] = s + f
+				}
+			case "$avg":
+				if f, ok := toFloat(v); ok {
+					sums[gi][e.Key] += f
+					counts[gi][e.Key]++
+					g[e.Key] = sums[gi][e.Key] / counts[gi][e.Key]
+				} else if !seen {
+					g[e.Key] = nil
+				}
+			case "$min", "$max":
+				if c, ok := compareValues(v, cur); !seen || (ok && (c < 0) == (acc[0].Key == "$min") && c != 0) {
+					g[e.Key] = v
+				}
+			case "$first":
+				if !seen {
+					g[e.Key] = v
+				}
+			case "$last":
+				g[e.Key] = v
+			case "$push", "$addToSet":
+				list, _ := cur.(bson.A)
+				if acc[0].Key == "$addToSet" {
+					dup := false
+					for _, x := range list {
+						if valuesEqual(x, v) {
+							dup = true
+							break
+						}
+					}
+					if dup {
+						g[e.Key] = list
+						continue
+					}
+				}
+				g[e.Key] = append(list, v)
+			default:
+				return nil, fmt.Errorf("memory: unsupported accumulator %s", acc[0].Key)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+func isInt(v any) bool {
+	switch v.(type) {
+	case int, int32, int64:
+		return true
+	}
+	return false
+}
+
+// evalExpr resolves "$path" strings and documents of them, other values are
+// constants.
+func evalExpr(doc bson.M, expr any) any {
+	switch x := expr.(type) {
+	case string:
+		if strings.HasPrefix(x, "$") {
+			if vs, ok := lookupPath(doc, strings.Split(x[1:], ".")); ok && len(vs) > 0 {
+				return vs[0]
+			}
+			return nil
+		}
+	case bson.D:
+		out := bson.M{}
+		for _, e := range x {
+			out[e.Key] = evalExpr(doc, e.Value)
+		}
+		return out
+	case bson.M:
+		out := bson.M{}
+		for k, v := range x {
+			out[k] = evalExpr(doc, v)
+		}
+		return out
+	}
+	return expr
+}
+
+// sortDocs orders documents like the server, values of different types are
+// ordered by their bson type.
+func sortDocs(docs []bson.M, sorts []SortField) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, s := range sorts {
+			path := strings.Split(mgoField(s.Field), ".")
+			var a, b any
+			if vs, ok := lookupPath(docs[i], path); ok && len(vs) > 0 {
+				a = vs[0]
+			}
+			if vs, ok := lookupPath(docs[j], path); ok && len(vs) > 0 {
+				b = vs[0]
+			}
+			c, ok := compareValues(a, b)
+			if !ok {
+				c = typeOrder(a) - typeOrder(b)
+			}
+			if c != 0 {
+				return (c < 0) != s.Desc
+			}
+		}
+		return false
+	})
+}
+
+func typeOrder(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int, int32, int64, float32, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M, bson.D:
+		return 3
+	case bson.A:
+		return 4
+	case primitive.Binary:
+		return 5
+	case primitive.ObjectID:
+		return 6
+	case bool:
+		return 7
+	case primitive.DateTime:
+		return 8
+	}
+	return 9
+}
+
+func projectDoc(doc bson.M, fields []string) bson.M {
+	out := bson.M{"_id": doc["_id"]}
+	for _, f := range fields {
+		f = mgoField(f)
+		if vs, ok := lookupPath(doc, strings.Split(f, ".")); ok && len(vs) == 1 {
+			setPath(out, f, vs[0])
+		}
+	}
+	return out
+}
+
+func decodeDoc(doc bson.M, v any) error {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, v)
+}
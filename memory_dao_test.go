@@ -0,0 +1,94 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+type memoryUserModel struct {
+	BaseModel `bson:",inline"`
+	Name      string `bson:"name"`
+	Role      string `bson:"role"`
+}
+
+type memoryUser struct {
+	ID      HID
+	Name    string
+	Role    string
+	Version int64
+}
+
+func TestMemoryDAO(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[memoryUserModel]()
+	repo := NewBaseRepository[memoryUserModel, memoryUser](dao, nil)
+
+	for _, name := range []string{"ann", "bob", "cat", "dan"} {
+		if dr := repo.Save(ctx, memoryUser{Name: name, Role: "user"}); dr.Error != nil || len(dr.ID()) != 20 {
+			t.Fatalf("Save(%s) = %+v", name, dr)
+		}
+	}
+
+	ann, dr := repo.FindOne(ctx, Where("name").Eq("ann"))
+	if dr.Error != nil || ann.Version != 1 {
+		t.Fatalf("FindOne() = %+v, %v", ann, dr.Error)
+	}
+
+	ann.Role = "admin"
+	if dr := repo.Save(ctx, ann); dr.Error != nil {
+		t.Fatalf("update: %v", dr.Error)
+	}
+	var e Error
+	if dr := repo.Save(ctx, ann); !errors.As(dr.Error, &e) || e.Code != ErrVersionConflict {
+		t.Errorf("stale update = %v", dr.Error)
+	}
+
+	if users, _ := repo.Find(ctx, Where("name").Prefix("a").Or(Where("role").Eq("admin"))); len(users) != 1 {
+		t.Errorf("Find() = %v", users)
+	}
+
+	users, total, _ := repo.Paging(ctx, Criteria("role != ?", "admin").OrderBy("-name"), PagingQuery{Page: 1, Count: 2})
+	if total != 3 || len(users) != 1 || users[0].Name != "bob" {
+		t.Errorf("Paging() = %v, %d", users, total)
+	}
+
+	if dr := repo.Remove(ctx, Where("name").Eq("bob")); dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	if n := repo.Count(ctx, CriteriaBuilder{}); n != 3 {
+		t.Errorf("Count() after Remove = %d", n)
+	}
+	if n := repo.Count(ctx, CriteriaBuilder{}.OnlyDeleted()); n != 1 {
+		t.Errorf("Count() of deleted = %d", n)
+	}
+	if dr := repo.Restore(ctx, Where("name").Eq("bob")); dr.Error != nil || repo.Count(ctx, CriteriaBuilder{}) != 4 {
+		t.Errorf("Restore() = %v", dr.Error)
+	}
+
+	if dr := dao.UpdateMany(ctx, bson.M{"role": "user"}, bson.M{"role": "member"}); dr.Count != 3 {
+		t.Errorf("UpdateMany() = %+v", dr)
+	}
+
+	dr = dao.BulkWrite(ctx, []BulkOp{
+		InsertOp(memoryUserModel{Name: "eve"}),
+		InsertOp(memoryUserModel{BaseModel: BaseModel{ID: ann.ID.String()}}),
+		UpsertOp(bson.M{"_id": "x"}, bson.M{"name": "xan"}),
+		DeleteOp(bson.M{"role": "member"}).All(),
+	}, false)
+	if dr.Error == nil || dr.Inserted != 1 || dr.Upserted != 1 || dr.Deleted != 3 || dr.Ops[1].OK || len(dr.IDs) != 2 {
+		t.Errorf("BulkWrite() = %+v", dr)
+	}
+	if n, _ := dao.Count(ctx, nil); n != 3 {
+		t.Errorf("documents after BulkWrite = %d", n)
+	}
+
+	if dr := repo.HardRemove(ctx, Where("id").Eq("x")); dr.Count != 1 {
+		t.Errorf("HardRemove() = %+v", dr)
+	}
+	if _, dr := dao.FindOne(ctx, bson.M{"_id": "x"}); !errors.Is(dr.Error, mongo.ErrNoDocuments) {
+		t.Errorf("FindOne() of a removed document = %v", dr.Error)
+	}
+}
@@ -0,0 +1,409 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration changes the schema or the data of the database. Migrations run
+// in the order of their IDs, so prefix them with a date, e.g.
+// 20261017_user_email_lower. Down is optional, a migration without it cannot
+// be rolled back.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+var (
+	migrations   = map[string]Migration{}
+	migrationMux = &sync.Mutex{}
+)
+
+// RegisterMigration adds a migration to the registry, usually from an init
+// function. It panics when the ID is already registered.
+func RegisterMigration(m Migration) {
+	migrationMux.Lock()
+	defer migrationMux.Unlock()
+
+	if m.ID == "" || m.Up == nil {
+		panic("migration: ID and Up are required")
+	}
+	if _, ok := migrations[m.ID]; ok {
+		panic(fmt.Sprintf("migration: %s already exist", m.ID))
+	}
+	migrations[m.ID] = m
+}
+
+// Migrations returns the registered migrations sorted by ID.
+func Migrations() []Migration {
+	migrationMux.Lock()
+	defer migrationMux.Unlock()
+
+	ms := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
+
+type MigrationRecord struct {
+	ID          string    `json:"id" bson:"_id,minsize"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"applied_at" bson:"applied_at"`
+}
+
+type MigrationLock struct {
+	ID        string    `json:"id" bson:"_id,minsize"`
+	Owner     string    `json:"owner" bson:"owner"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+type MigrationStatus struct {
+	ID          string
+	Description string
+	AppliedAt   *time.Time
+}
+
+var ErrMigrationLocked = errors.New("migration: locked by another instance")
+
+const migrationLockID = "migrate"
+
+// Migrator applies migrations and records them in Applied. Only the holder of
+// the lock in Locks migrates, a lock whose holder died expires after LockTTL.
+// With DryRun the migrations that would run are written to Out instead.
+type Migrator struct {
+	Logger     *Logger
+	DB         *mongo.Database
+	Applied    BaseDAO[MigrationRecord]
+	Locks      BaseDAO[MigrationLock]
+	Migrations []Migration
+	LockTTL    time.Duration
+	DryRun     bool
+	Out        io.Writer
+	owner      string
+}
+
+// NewMigrator keeps the applied migrations in the collections
+// mongo.migrate.collection, default migrations, and <collection>_lock of
+// the default database.
+func NewMigrator(logger *Logger, client *mongo.Client) *Migrator {
+	col := viper.GetString("mongo.migrate.collection")
+	if col == "" {
+		col = "migrations"
+	}
+	lockTTL := viper.GetDuration("mongo.migrate.lock_ttl")
+	if lockTTL <= 0 {
+		lockTTL = 10 * time.Minute
+	}
+
+	applied := NewMongoDAO[MigrationRecord](logger, client, &MongoDAOOptions{Table: col})
+	return &Migrator{
+		logger,
+		applied.Db,
+		applied,
+		NewMongoDAO[MigrationLock](logger, client, &MongoDAOOptions{Table: col + "_lock"}),
+		Migrations(),
+		lockTTL,
+		false,
+		os.Stdout,
+		"",
+	}
+}
+
+func (m *Migrator) WithDryRun(dryRun bool) *Migrator {
+	m.DryRun = dryRun
+	return m
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, dr := m.Applied.Find(ctx, bson.M{})
+	if dr.Error != nil {
+		return nil, dr.Error
+	}
+	at := map[string]time.Time{}
+	for _, r := range applied {
+		at[r.ID] = r.AppliedAt
+	}
+
+	status := make([]MigrationStatus, 0, len(m.Migrations))
+	for _, mg := range m.Migrations {
+		s := MigrationStatus{ID: mg.ID, Description: mg.Description}
+		if t, ok := at[mg.ID]; ok {
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Up applies the pending migrations in order, up to and including target when
+// it is not empty. It stops at the first error, the migrations applied before
+// stay applied.
+func (m *Migrator) Up(ctx context.Context, target string) ([]string, error) {
+	if target != "" && !m.registered(target) {
+		return nil, fmt.Errorf("migration: %s is not registered", target)
+	}
+
+	return m.locked(ctx, func() ([]string, error) {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var done []string
+		for i, s := range status {
+			if s.AppliedAt == nil {
+				mg := m.Migrations[i]
+				if err := m.run(ctx, "up", mg, mg.Up); err != nil {
+					return done, err
+				}
+				if !m.DryRun {
+					if dr := m.Applied.Insert(ctx, MigrationRecord{mg.ID, mg.Description, time.Now()}); dr.Error != nil {
+						return done, dr.Error
+					}
+				}
+				done = append(done, mg.ID)
+			}
+			if s.ID == target {
+				break
+			}
+		}
+		return done, nil
+	})
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]string, error) {
+	return m.locked(ctx, func() ([]string, error) {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var done []string
+		for i := len(status) - 1; i >= 0 && len(done) < steps; i-- {
+			if status[i].AppliedAt == nil {
+				continue
+			}
+			mg := m.Migrations[i]
+			if mg.Down == nil {
+				return done, fmt.Errorf("migration: %s cannot be rolled back", mg.ID)
+			}
+			if err := m.run(ctx, "down", mg, mg.Down); err != nil {
+				return done, err
+			}
+			if !m.DryRun {
+				if dr := m.Applied.Delete(ctx, bson.M{"_id": mg.ID}); dr.Error != nil {
+					return done, dr.Error
+				}
+			}
+			done = append(done, mg.ID)
+		}
+		return done, nil
+	})
+}
+
+// run runs fn while renewing the lock in the background, fn's context is
+// cancelled when the lock is lost so that two instances never migrate at once.
+func (m *Migrator) run(ctx context.Context, dir string, mg Migration, fn func(context.Context, *mongo.Database) error) error {
+	if m.DryRun {
+		_, err := fmt.Fprintf(m.Out, "%-4s %s %s\n", dir, mg.ID, mg.Description)
+		return err
+	}
+
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lost error
+	stop := make(chan struct{})
+	renewer := &sync.WaitGroup{}
+	renewer.Add(1)
+	go func() {
+		defer renewer.Done()
+		ticker := time.NewTicker(m.LockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := m.lock(ctx); err != nil {
+					lost = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := fn(runCtx, m.DB)
+	close(stop)
+	renewer.Wait()
+	if err != nil {
+		if lost != nil {
+			err = errors.Join(err, fmt.Errorf("renew lock: %w", lost))
+		}
+		return fmt.Errorf("migration: %s %s: %w", dir, mg.ID, err)
+	}
+	m.Logger.Info("migration "+dir, zap.String("id", mg.ID), zap.Duration("took", time.Since(start)))
+	return nil
+}
+
+// upWhenUnlocked runs Up, and while another instance holds the lock waits
+// for it to finish and runs Up again, which then has nothing left to apply.
+func (m *Migrator) upWhenUnlocked(ctx context.Context, target string, poll time.Duration) ([]string, error) {
+	for {
+		done, err := m.Up(ctx, target)
+		if !errors.Is(err, ErrMigrationLocked) {
+			return done, err
+		}
+		m.Logger.Info("migration locked by another instance, waiting")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+func (m *Migrator) registered(id string) bool {
+	for _, mg := range m.Migrations {
+		if mg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// locked runs fn holding the migration lock, a dry run does not lock.
+func (m *Migrator) locked(ctx context.Context, fn func() ([]string, error)) ([]string, error) {
+	if m.DryRun {
+		return fn()
+	}
+
+	if m.owner == "" {
+		host, _ := os.Hostname()
+		m.owner = fmt.Sprintf("%s/%d/%s", host, os.Getpid(), NewID())
+	}
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if dr := m.Locks.Delete(context.WithoutCancel(ctx), bson.M{"_id": migrationLockID, "owner": m.owner}); dr.Error != nil {
+			m.Logger.Error("migrator.unlock", zap.Error(dr.Error))
+		}
+	}()
+	return fn()
+}
+
+// lock takes the migration lock or extends it when it is already held, it is
+// called before every migration and while it runs so that a long run keeps it.
+func (m *Migrator) lock(ctx context.Context) error {
+	expires := time.Now().Add(m.LockTTL)
+	cur, dr := m.Locks.FindOne(ctx, bson.M{"_id": migrationLockID})
+	if errors.Is(dr.Error, mongo.ErrNoDocuments) {
+		if dr := m.Locks.Insert(ctx, MigrationLock{migrationLockID, m.owner, expires}); dr.Error != nil {
+			// lost the race for the lock
+			if _, fdr := m.Locks.FindOne(ctx, bson.M{"_id": migrationLockID}); fdr.Error == nil {
+				return ErrMigrationLocked
+			}
+			return dr.Error
+		}
+		return nil
+	} else if dr.Error != nil {
+		return dr.Error
+	}
+
+	if cur.Owner != m.owner && cur.ExpiresAt.After(time.Now()) {
+		return ErrMigrationLocked
+	}
+	dr = m.Locks.Update(ctx,
+		bson.M{"_id": migrationLockID, "owner": cur.Owner},
+		bson.M{"owner": m.owner, "expires_at": expires})
+	if dr.Error != nil {
+		return dr.Error
+	}
+	if dr.Count == 0 {
+		// nothing modified, either the lock was taken first or it is ours
+		// and unchanged within the precision of the stored time
+		if cur, dr = m.Locks.FindOne(ctx, bson.M{"_id": migrationLockID}); dr.Error != nil {
+			return dr.Error
+		} else if cur.Owner != m.owner {
+			return ErrMigrationLocked
+		}
+	}
+	return nil
+}
+
+var (
+	migrateCmd    = pflag.String("migrate", "", "Run the database migrations `up`, `down` or print their `status`, then exit.")
+	migrateTo     = pflag.String("migrate-to", "", "Migrate up to and including the migration `ID`.")
+	migrateSteps  = pflag.Int("migrate-steps", 1, "Number of migrations rolled back by --migrate down.")
+	migrateDryRun = pflag.Bool("migrate-dry-run", false, "Print the migrations that would run without running them.")
+)
+
+// Migrate runs the command of the --migrate flags after LoadConfig parsed
+// them, it returns true when the service should exit afterwards. Without the
+// flags and with mongo.migrate.auto the pending migrations are applied and
+// the service starts, replicas starting together wait for the one holding
+// the lock.
+//
+//	hin.LoadConfig("config")
+//	client, cleanup, err := hin.NewMongoDB(logger)
+//	if exit, err := hin.Migrate(ctx, client, logger); exit || err != nil {
+//		...
+//	}
+func Migrate(ctx context.Context, client *mongo.Client, logger *Logger) (bool, error) {
+	cmd := *migrateCmd
+	if cmd == "" {
+		if !viper.GetBool("mongo.migrate.auto") {
+			return false, nil
+		}
+		cmd = "up"
+	}
+
+	m := NewMigrator(logger, client).WithDryRun(*migrateDryRun)
+	var err error
+	switch cmd {
+	case "up":
+		if *migrateCmd == "" {
+			_, err = m.upWhenUnlocked(ctx, *migrateTo, 5*time.Second)
+		} else {
+			_, err = m.Up(ctx, *migrateTo)
+		}
+	case "down":
+		_, err = m.Down(ctx, *migrateSteps)
+	case "status":
+		var status []MigrationStatus
+		if status, err = m.Status(ctx); err == nil {
+			for _, s := range status {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(m.Out, "%-25s %s %s\n", applied, s.ID, s.Description)
+			}
+		}
+	default:
+		err = fmt.Errorf("migration: unknown command %q", cmd)
+	}
+	return *migrateCmd != "", err
+}
@@ -0,0 +1,129 @@
+package hin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	var ran []string
+	step := func(name string) func(context.Context, *mongo.Database) error {
+		return func(context.Context, *mongo.Database) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+
+	out := new(bytes.Buffer)
+	m := &Migrator{
+		Logger:  &Logger{zap.NewNop()},
+		Applied: NewMemoryDAO[MigrationRecord](),
+		Locks:   NewMemoryDAO[MigrationLock](),
+		Migrations: []Migration{
+			{ID: "001", Description: "first", Up: step("up1"), Down: step("down1")},
+			{ID: "002", Description: "second", Up: step("up2")},
+			{ID: "003", Description: "third", Up: step("up3"), Down: step("down3")},
+		},
+		LockTTL: time.Minute,
+		Out:     out,
+	}
+
+	m.DryRun = true
+	if done, err := m.Up(ctx, ""); err != nil || len(done) != 3 || len(ran) != 0 {
+		t.Fatalf("dry run Up() = %v, %v, ran %v", done, err, ran)
+	}
+	if out.String() != "up   001 first\nup   002 second\nup   003 third\n" {
+		t.Errorf("dry run output = %q", out.String())
+	}
+	m.DryRun = false
+
+	if done, err := m.Up(ctx, "002"); err != nil || !reflect.DeepEqual(done, []string{"001", "002"}) {
+		t.Fatalf("Up(002) = %v, %v", done, err)
+	}
+	if done, _ := m.Up(ctx, ""); !reflect.DeepEqual(done, []string{"003"}) {
+		t.Errorf("Up() = %v", done)
+	}
+	if status, _ := m.Status(ctx); status[2].AppliedAt == nil {
+		t.Errorf("Status() = %+v", status)
+	}
+	if _, err := m.Up(ctx, "004"); err == nil {
+		t.Error("Up() to an unknown migration must fail")
+	}
+
+	done, err := m.Down(ctx, 2)
+	if !reflect.DeepEqual(done, []string{"003"}) || err == nil {
+		t.Errorf("Down(2) = %v, %v", done, err)
+	}
+	if !reflect.DeepEqual(ran, []string{"up1", "up2", "up3", "down3"}) {
+		t.Errorf("ran %v", ran)
+	}
+	if n, _ := m.Applied.Count(ctx, nil); n != 2 {
+		t.Errorf("%d migrations applied", n)
+	}
+
+	// the lock of a live instance blocks, an expired one is taken over
+	other := MigrationLock{migrationLockID, "other", time.Now().Add(time.Minute)}
+	m.Locks.Insert(ctx, other)
+	if _, err := m.Up(ctx, ""); !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("Up() with a held lock = %v", err)
+	}
+	m.Locks.Update(ctx, map[string]any{"_id": migrationLockID}, map[string]any{"expires_at": time.Now().Add(-time.Second)})
+	if done, err := m.Up(ctx, ""); err != nil || !reflect.DeepEqual(done, []string{"003"}) {
+		t.Errorf("Up() with an expired lock = %v, %v", done, err)
+	}
+	if n, _ := m.Locks.Count(ctx, nil); n != 0 {
+		t.Error("lock not released")
+	}
+}
+
+func TestMigratorLockRenewal(t *testing.T) {
+	ctx := context.Background()
+	locks := NewMemoryDAO[MigrationLock]()
+	m := &Migrator{
+		Logger:  &Logger{zap.NewNop()},
+		Applied: NewMemoryDAO[MigrationRecord](),
+		Locks:   locks,
+		LockTTL: 30 * time.Millisecond,
+		Out:     new(bytes.Buffer),
+	}
+
+	// a migration longer than the ttl keeps the lock
+	m.Migrations = []Migration{{ID: "001", Up: func(context.Context, *mongo.Database) error {
+		time.Sleep(100 * time.Millisecond)
+		if lock, dr := locks.FindOne(ctx, map[string]any{"_id": migrationLockID}); dr.Error != nil || lock.ExpiresAt.Before(time.Now()) {
+			t.Errorf("lock during a long migration = %+v, %v", lock, dr.Error)
+		}
+		return nil
+	}}}
+	if _, err := m.Up(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a migration that lost the lock is cancelled
+	m.Migrations = append(m.Migrations, Migration{ID: "002", Up: func(ctx context.Context, _ *mongo.Database) error {
+		locks.Update(ctx, map[string]any{"_id": migrationLockID}, map[string]any{"owner": "other", "expires_at": time.Now().Add(time.Minute)})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}})
+	if _, err := m.Up(ctx, ""); !errors.Is(err, context.Canceled) || !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("Up() after losing the lock = %v", err)
+	}
+
+	// auto mode waits for the instance holding the lock
+	locks.Update(ctx, map[string]any{"_id": migrationLockID}, map[string]any{"expires_at": time.Now().Add(50 * time.Millisecond)})
+	m.Migrations[1].Up = func(context.Context, *mongo.Database) error { return nil }
+	if done, err := m.upWhenUnlocked(ctx, "", 10*time.Millisecond); err != nil || !reflect.DeepEqual(done, []string{"002"}) {
+		t.Errorf("upWhenUnlocked() = %v, %v", done, err)
+	}
+}
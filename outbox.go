@@ -0,0 +1,237 @@
+package hin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSent    OutboxStatus = "sent"
+	// OutboxFailed records gave up after the maximum number of attempts.
+	OutboxFailed OutboxStatus = "failed"
+)
+
+// OutboxRecord is a domain event waiting to be published, Payload is the
+// event encoded as JSON.
+type OutboxRecord struct {
+	ID            string       `json:"id" bson:"_id,minsize"`
+	Event         string       `json:"event" bson:"event"`
+	AggregateID   string       `json:"aggregate_id" bson:"aggregate_id"`
+	Payload       []byte       `json:"payload" bson:"payload"`
+	Status        OutboxStatus `json:"status" bson:"status"`
+	Attempts      int          `json:"attempts" bson:"attempts"`
+	LastError     string       `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt     time.Time    `json:"created_at" bson:"created_at"`
+	SentAt        *time.Time   `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+// Publisher delivers outbox records to a broker. Delivery is at least once,
+// so consumers must tolerate the same record twice.
+type Publisher interface {
+	Publish(ctx context.Context, record OutboxRecord) error
+}
+
+// Outbox stores the events of saved entities in the transaction writing the
+// entity, see BaseRepo.WithOutbox. Without a Client the writes are not
+// transactional.
+type Outbox struct {
+	Dao    BaseDAO[OutboxRecord]
+	Client *mongo.Client
+}
+
+func NewOutbox(dao BaseDAO[OutboxRecord], client *mongo.Client) *Outbox {
+	return &Outbox{
+		dao,
+		client,
+	}
+}
+
+func (o *Outbox) Add(ctx context.Context, aggregateID string, events ...DomainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	records := make([]OutboxRecord, 0, len(events))
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		records = append(records, OutboxRecord{
+			ID:            NewID().String(),
+			Event:         e.EventName(),
+			AggregateID:   aggregateID,
+			Payload:       payload,
+			Status:        OutboxPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	return o.Dao.InsertMany(ctx, records).Error
+}
+
+func (o *Outbox) CreateIndexes(ctx context.Context) *MDR {
+	return o.Dao.CreateIndexes(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+	})
+}
+
+func (o *Outbox) transaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	if o == nil || o.Client == nil {
+		return fn(ctx)
+	}
+	return WithTransaction(ctx, o.Client, fn)
+}
+
+// OutboxRelay moves pending outbox records to a Publisher. A record is leased
+// before it is published so that several relays can share an outbox, failed
+// attempts are retried with exponential backoff until MaxAttempts.
+type OutboxRelay struct {
+	Logger      *Logger
+	Outbox      *Outbox
+	Publisher   Publisher
+	Interval    time.Duration
+	BatchSize   int64
+	MaxAttempts int
+	Lease       time.Duration
+	Backoff     func(attempts int) time.Duration
+}
+
+// NewOutboxRelay reads mongo.outbox.interval, mongo.outbox.batch and
+// mongo.outbox.max_attempts, defaulting to one second, 100 and 10.
+func NewOutboxRelay(logger *Logger, outbox *Outbox, publisher Publisher) *OutboxRelay {
+	relay := &OutboxRelay{
+		Logger:      logger,
+		Outbox:      outbox,
+		Publisher:   publisher,
+		Interval:    viper.GetDuration("mongo.outbox.interval"),
+		BatchSize:   viper.GetInt64("mongo.outbox.batch"),
+		MaxAttempts: viper.GetInt("mongo.outbox.max_attempts"),
+		Lease:       time.Minute,
+		Backoff:     outboxBackoff,
+	}
+	if relay.Interval <= 0 {
+		relay.Interval = time.Second
+	}
+	if relay.BatchSize <= 0 {
+		relay.BatchSize = 100
+	}
+	if relay.MaxAttempts <= 0 {
+		relay.MaxAttempts = 10
+	}
+	return relay
+}
+
+// outboxBackoff waits one second after the first failure and doubles up to
+// ten minutes.
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < 10*time.Minute; i++ {
+		d *= 2
+	}
+	return min(d, 10*time.Minute)
+}
+
+// Run relays records until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Relay(ctx); err != nil {
+			r.Logger.Error("outboxRelay.Run", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes one batch of due records and returns how many were sent.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	now := time.Now()
+	filter := bson.M{"status": OutboxPending, "next_attempt_at": bson.M{"$lte": now}}
+	records, dr := r.Outbox.Dao.Find(ctx, filter, WithSort("created_at"), WithLimit(r.BatchSize))
+	if dr.Error != nil {
+		return 0, dr.Error
+	}
+
+	sent := 0
+	for _, rec := range records {
+		if ok, err := r.claim(ctx, rec, now); err != nil {
+			return sent, err
+		} else if !ok {
+			continue
+		}
+
+		rec.Attempts++
+		update := bson.M{"attempts": rec.Attempts}
+		if err := r.Publisher.Publish(ctx, rec); err != nil {
+			update["last_error"] = err.Error()
+			update["next_attempt_at"] = time.Now().Add(r.Backoff(rec.Attempts))
+			if rec.Attempts >= r.MaxAttempts {
+				update["status"] = OutboxFailed
+			}
+			r.Logger.Warn("outboxRelay.Relay", zap.String("id", rec.ID), zap.Int("attempts", rec.Attempts), zap.Error(err))
+		} else {
+			update["status"] = OutboxSent
+			update["sent_at"] = time.Now()
+			sent++
+		}
+
+		if dr := r.Outbox.Dao.Update(ctx, bson.M{"_id": rec.ID}, update); dr.Error != nil {
+			return sent, dr.Error
+		}
+	}
+	return sent, nil
+}
+
+// claim pushes next_attempt_at past the lease, it fails when another relay
+// took the record since it was read.
+func (r *OutboxRelay) claim(ctx context.Context, rec OutboxRecord, now time.Time) (bool, error) {
+	filter := bson.M{"_id": rec.ID, "status": OutboxPending, "next_attempt_at": rec.NextAttemptAt}
+	dr := r.Outbox.Dao.Update(ctx, filter, bson.M{"next_attempt_at": now.Add(r.Lease)})
+	return dr.Count == 1, dr.Error
+}
+
+// MemoryPublisher keeps published records in memory, Fail makes Publish
+// return its error.
+type MemoryPublisher struct {
+	Fail func(record OutboxRecord) error
+
+	mux     sync.Mutex
+	records []OutboxRecord
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, record OutboxRecord) error {
+	if p.Fail != nil {
+		if err := p.Fail(record); err != nil {
+			return err
+		}
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.records = append(p.records, record)
+	return nil
+}
+
+func (p *MemoryPublisher) Records() []OutboxRecord {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]OutboxRecord(nil), p.records...)
+}
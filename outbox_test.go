@@ -0,0 +1,97 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	dao := &testDAO[OutboxRecord]{}
+	outbox := NewOutbox(dao, nil)
+
+	if err := outbox.Add(ctx, "u1", userRenamed{Name: "ann"}, userDeleted{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := &MemoryPublisher{Fail: func(r OutboxRecord) error {
+		if r.Event == "user.deleted" {
+			return errors.New("broker down")
+		}
+		return nil
+	}}
+	relay := NewOutboxRelay(&Logger{zap.NewNop()}, outbox, pub)
+	relay.MaxAttempts = 2
+	relay.Backoff = func(int) time.Duration { return 0 }
+
+	n, err := relay.Relay(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Relay() = %d, %v", n, err)
+	}
+	if rs := pub.Records(); len(rs) != 1 || rs[0].AggregateID != "u1" || string(rs[0].Payload) != `{"Name":"ann"}` {
+		t.Fatalf("published %+v", rs)
+	}
+
+	// the sent record is not published again, the failed one is retried
+	if n, err := relay.Relay(ctx); err != nil || n != 0 {
+		t.Fatalf("second Relay() = %d, %v", n, err)
+	}
+	if n, _ := dao.Count(ctx, bson.M{"status": OutboxFailed, "attempts": 2}); n != 1 {
+		t.Errorf("failed records = %d, want 1", n)
+	}
+	if n, _ := dao.Count(ctx, bson.M{"status": OutboxSent}); n != 1 {
+		t.Errorf("sent records = %d, want 1", n)
+	}
+
+	if d := outboxBackoff(1); d != time.Second {
+		t.Errorf("outboxBackoff(1) = %v", d)
+	}
+	if d := outboxBackoff(100); d != 10*time.Minute {
+		t.Errorf("outboxBackoff(100) = %v", d)
+	}
+}
+
+func TestRepositoryOutbox(t *testing.T) {
+	ctx := context.Background()
+	dao := &failingDAO[memoryUserModel]{MemoryDAO: NewMemoryDAO[memoryUserModel]()}
+	records := NewMemoryDAO[OutboxRecord]()
+	outbox := NewOutbox(records, nil)
+	repo := NewBaseRepository[memoryUserModel, *eventUser](dao, nil).WithOutbox(outbox)
+
+	u := &eventUser{}
+	u.Rename("ann")
+	dr := repo.Save(ctx, u)
+	if dr.Error != nil || len(u.Events()) != 0 {
+		t.Fatalf("Save() = %v, %d events left", dr.Error, len(u.Events()))
+	}
+	if n, _ := records.Count(ctx, bson.M{"status": OutboxPending, "aggregate_id": dr.ID()}); n != 1 {
+		t.Fatalf("pending records = %d, want 1", n)
+	}
+
+	// a failed write adds no record
+	dao.err = errors.New("write failed")
+	u = &eventUser{}
+	u.Rename("bob")
+	if dr := repo.Save(ctx, u); dr.Error == nil || len(u.Events()) != 1 {
+		t.Fatalf("Save() = %v, %d events left", dr.Error, len(u.Events()))
+	}
+	if n, _ := records.Count(ctx, bson.M{}); n != 1 {
+		t.Errorf("records = %d, want 1", n)
+	}
+
+	pub := &MemoryPublisher{}
+	relay := NewOutboxRelay(&Logger{zap.NewNop()}, outbox, pub)
+	if n, err := relay.Relay(ctx); err != nil || n != 1 {
+		t.Fatalf("Relay() = %d, %v", n, err)
+	}
+	if rs := pub.Records(); len(rs) != 1 || rs[0].AggregateID != dr.ID() || rs[0].Event != "user.renamed" {
+		t.Errorf("published %+v", rs)
+	}
+	if n, _ := records.Count(ctx, bson.M{"status": OutboxSent}); n != 1 {
+		t.Errorf("sent records = %d, want 1", n)
+	}
+}
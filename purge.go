@@ -0,0 +1,49 @@
+package hin
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Purger permanently removes documents soft deleted longer than retention ago,
+// it is implemented by BaseRepo.
+type Purger interface {
+	Purge(ctx context.Context, retention time.Duration) *MDR
+}
+
+// RunPurge periodically purges soft deleted documents until ctx is done. The
+// interval and retention are read from mongo.purge.interval and
+// mongo.purge.retention, defaulting to one hour and 30 days. It purges the
+// documents of every tenant.
+func RunPurge(ctx context.Context, logger *Logger, purgers ...Purger) {
+	interval := viper.GetDuration("mongo.purge.interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retention := viper.GetDuration("mongo.purge.retention")
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+
+	all := AllTenants(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, p := range purgers {
+			if r := p.Purge(all, retention); r.Error != nil {
+				logger.Error("RunPurge", zap.Error(r.Error))
+			} else if r.Count > 0 {
+				logger.Info("RunPurge", zap.Int64("purged", r.Count))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -186,6 +186,10 @@ const (
 	ErrTokenInvalid
 	ErrTokenRequired
 	ErrPermissionDenied
+	// ErrVersionConflict 版本冲突 409
+	ErrVersionConflict
+	// ErrTenantRequired 缺少租户 403
+	ErrTenantRequired
 )
 
 func init() {
@@ -203,4 +207,6 @@ func init() {
 	Register(ErrCoder{ErrTokenInvalid, http.StatusUnauthorized, "token invalid"})
 	Register(ErrCoder{ErrTokenRequired, http.StatusUnauthorized, "token required"})
 	Register(ErrCoder{ErrPermissionDenied, http.StatusForbidden, "permission denied"})
+	Register(ErrCoder{ErrVersionConflict, http.StatusConflict, "version conflict"})
+	Register(ErrCoder{ErrTenantRequired, http.StatusForbidden, "tenant required"})
 }
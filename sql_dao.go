@@ -0,0 +1,658 @@
+package hin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BaseSQLDAO is a BaseDAO on database/sql. The columns are named like the
+// bson fields of T, with _id as id, and accept the same filter documents as
+// the Mongo DAO, so BaseRepo and soft delete work unchanged. Nested
+// documents and arrays are stored as JSON text, filters on nested fields are
+// not supported. Aggregate is not available.
+type BaseSQLDAO[T any] struct {
+	Logger  *Logger
+	DB      *sql.DB
+	Table   string
+	Dialect SQLDialect
+}
+
+type SQLDAOOptions struct {
+	Table   string
+	Dialect SQLDialect
+}
+
+func NewSQLDAO[T any](
+	logger *Logger,
+	db *sql.DB,
+	opts *SQLDAOOptions,
+) *BaseSQLDAO[T] {
+	return &BaseSQLDAO[T]{
+		logger,
+		db,
+		opts.Table,
+		opts.Dialect,
+	}
+}
+
+// columns lists the bson fields of T by column name.
+func (d *BaseSQLDAO[T]) columns() map[string]reflect.Type {
+	var m T
+	cols := map[string]reflect.Type{}
+	for name, t := range bsonFields(reflect.TypeOf(m)) {
+		cols[sqlColumn(name)] = t
+	}
+	return cols
+}
+
+func (d *BaseSQLDAO[T]) writer() *sqlWriter {
+	cols := d.columns()
+	return &sqlWriter{dialect: d.Dialect, column: func(field string) (string, error) {
+		name := sqlColumn(field)
+		if _, ok := cols[name]; !ok {
+			return "", fmt.Errorf("sql: unknown column %s of %s", field, d.Table)
+		}
+		return d.Dialect.quote(name), nil
+	}}
+}
+
+func (d *BaseSQLDAO[T]) where(w *sqlWriter, filter any) (string, error) {
+	s, err := w.where(filter)
+	if err != nil || s == "" {
+		return "", err
+	}
+	return " WHERE " + s, nil
+}
+
+func (d *BaseSQLDAO[T]) Insert(ctx context.Context, model T) *MDR {
+	return d.InsertMany(ctx, []T{model})
+}
+
+func (d *BaseSQLDAO[T]) InsertMany(ctx context.Context, model []T) *MDR {
+	r := new(MDR)
+	for _, m := range model {
+		id, err := d.insert(ctx, d.DB, m)
+		if err != nil {
+			r.Error = err
+			return r
+		}
+		r.IDs = append(r.IDs, id)
+		r.Count++
+	}
+	return r
+}
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (d *BaseSQLDAO[T]) insert(ctx context.Context, db sqlExecer, model any) (string, error) {
+	doc, id, err := bulkInsertDoc(model)
+	if err != nil {
+		return "", err
+	}
+
+	var cols, phs []string
+	var args []any
+	for _, e := range doc {
+		v, err := sqlValue(e.Value)
+		if err != nil {
+			return "", err
+		}
+		cols = append(cols, d.Dialect.quote(sqlColumn(e.Key)))
+		args = append(args, v)
+		phs = append(phs, d.Dialect.placeholder(len(args)))
+	}
+
+	query := "INSERT INTO " + d.Dialect.quote(d.Table) + " (" + strings.Join(cols, ", ") + ") VALUES (" + strings.Join(phs, ", ") + ")"
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (d *BaseSQLDAO[T]) selectQuery(w *sqlWriter, filter any, o *FindOptions) (string, []string, error) {
+	where, err := d.where(w, filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	all := d.columns()
+	var names []string
+	if len(o.Projection) > 0 {
+		names = append(names, "id")
+		for _, f := range o.Projection {
+			if name := sqlColumn(mgoField(f)); name != "id" {
+				if _, ok := all[name]; !ok {
+					return "", nil, fmt.Errorf("sql: unknown column %s of %s", f, d.Table)
+				}
+				names = append(names, name)
+			}
+		}
+	} else {
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	quoted := make([]string, 0, len(names))
+	for _, n := range names {
+		quoted = append(quoted, d.Dialect.quote(n))
+	}
+	query := "SELECT " + strings.Join(quoted, ", ") + " FROM " + d.Dialect.quote(d.Table) + where
+
+	// sort keys missing on T are ignored, as Mongo ignores missing fields
+	var orders []string
+	for _, s := range o.Sort {
+		name := sqlColumn(mgoField(s.Field))
+		if _, ok := all[name]; !ok {
+			continue
+		}
+		if s.Desc {
+			orders = append(orders, d.Dialect.quote(name)+" DESC")
+		} else {
+			orders = append(orders, d.Dialect.quote(name)+" ASC")
+		}
+	}
+	if len(orders) > 0 {
+		query += " ORDER BY " + strings.Join(orders, ", ")
+	}
+
+	// SQLite and MySQL only accept OFFSET after a LIMIT
+	switch {
+	case o.Limit > 0:
+		query += " LIMIT " + strconv.FormatInt(o.Limit, 10)
+	case o.Skip > 0 && d.Dialect == DialectMySQL:
+		query += " LIMIT 18446744073709551615"
+	case o.Skip > 0 && d.Dialect == DialectSQLite:
+		query += " LIMIT -1"
+	}
+	if o.Skip > 0 {
+		query += " OFFSET " + strconv.FormatInt(o.Skip, 10)
+	}
+	return query, names, nil
+}
+
+func (d *BaseSQLDAO[T]) Each(ctx context.Context, filter any, fn func(T) error, opts ...FindOption) error {
+	w := d.writer()
+	query, names, err := d.selectQuery(w, filter, newFindOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	types := d.columns()
+	values := make([]any, len(names))
+	ptrs := make([]any, len(names))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		doc := bson.M{}
+		for i, name := range names {
+			v, err := sqlScanValue(values[i], types[name])
+			if err != nil {
+				return fmt.Errorf("sql: column %s: %w", name, err)
+			}
+			if name == "id" {
+				name = "_id"
+			}
+			doc[name] = v
+		}
+
+		var m T
+		if err := decodeDoc(doc, &m); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (d *BaseSQLDAO[T]) Iter(ctx context.Context, filter any, opts ...FindOption) func(yield func(T, error) bool) {
+	return iterOf(func(fn func(T) error) error {
+		return d.Each(ctx, filter, fn, opts...)
+	})
+}
+
+func (d *BaseSQLDAO[T]) Find(ctx context.Context, filter any, opts ...FindOption) ([]T, *MDR) {
+	r := make([]T, 0)
+	err := d.Each(ctx, filter, func(m T) error {
+		r = append(r, m)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, newErrMDR(err)
+	}
+	return r, new(MDR).SetCount(int64(len(r)))
+}
+
+func (d *BaseSQLDAO[T]) FindOne(ctx context.Context, filter any, opts ...FindOption) (T, *MDR) {
+	var m T
+	r, dr := d.Find(ctx, filter, append(opts, WithLimit(1))...)
+	if dr.Error != nil {
+		return m, dr
+	}
+	if len(r) == 0 {
+		return m, newErrMDR(mongo.ErrNoDocuments)
+	}
+	return r[0], dr
+}
+
+func (d *BaseSQLDAO[T]) Update(ctx context.Context, filter any, model any) *MDR {
+	return d.update(ctx, d.DB, filter, model, false)
+}
+
+func (d *BaseSQLDAO[T]) UpdateById(ctx context.Context, id any, model any) *MDR {
+	dr := d.Update(ctx, bson.M{"_id": id}, model)
+	if dr.Error != nil {
+		return dr
+	}
+	return dr.setID(id)
+}
+
+func (d *BaseSQLDAO[T]) UpdateMany(ctx context.Context, filter any, model any) *MDR {
+	return d.update(ctx, d.DB, filter, model, true)
+}
+
+// update sets the fields of model like $set, without many only the first
+// matching row is updated.
+func (d *BaseSQLDAO[T]) update(ctx context.Context, db sqlExecer, filter any, model any, many bool) *MDR {
+	set, err := toBsonM(model)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		if k != "_id" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return new(MDR)
+	}
+	sort.Strings(keys)
+
+	w := d.writer()
+	sets := make([]string, 0, len(keys))
+	for _, k := range keys {
+		col, err := w.col(k)
+		if err != nil {
+			return newErrMDR(err)
+		}
+		sets = append(sets, col+" = "+w.bind(set[k]))
+	}
+
+	where, err := d.matchWhere(w, filter, many)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE "+d.Dialect.quote(d.Table)+" SET "+strings.Join(sets, ", ")+where, w.args...)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return &MDR{Count: n, Matched: n, Modified: n}
+}
+
+// matchWhere restricts a statement to the first matching row unless many,
+// through a derived table that MySQL also accepts.
+func (d *BaseSQLDAO[T]) matchWhere(w *sqlWriter, filter any, many bool) (string, error) {
+	where, err := d.where(w, filter)
+	if err != nil || many {
+		return where, err
+	}
+	id := d.Dialect.quote("id")
+	table := d.Dialect.quote(d.Table)
+	return " WHERE " + id + " IN (SELECT " + id + " FROM (SELECT " + id + " FROM " + table + where + " LIMIT 1) one)", nil
+}
+
+func (d *BaseSQLDAO[T]) Delete(ctx context.Context, filter any) *MDR {
+	return d.delete(ctx, d.DB, filter, true)
+}
+
+func (d *BaseSQLDAO[T]) delete(ctx context.Context, db sqlExecer, filter any, many bool) *MDR {
+	w := d.writer()
+	where, err := d.matchWhere(w, filter, many)
+	if err != nil {
+		return newErrMDR(err)
+	}
+
+	res, err := db.ExecContext(ctx, "DELETE FROM "+d.Dialect.quote(d.Table)+where, w.args...)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return newErrMDR(err)
+	}
+	return &MDR{Count: n, Deleted: n}
+}
+
+func (d *BaseSQLDAO[T]) Count(ctx context.Context, filter any) (int64, error) {
+	w := d.writer()
+	where, err := d.where(w, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err = d.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.Dialect.quote(d.Table)+where, w.args...).Scan(&n)
+	return n, err
+}
+
+func (d *BaseSQLDAO[T]) Paging(ctx context.Context, filter any, paging PagingQuery, opts ...FindOption) ([]T, int64, *MDR) {
+	opts = append(opts, WithLimit(paging.Count), WithSkip(paging.Count*paging.Page))
+	r, dr := d.Find(ctx, filter, opts...)
+	if dr.Error != nil {
+		return nil, 0, dr
+	}
+	total, err := d.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, newErrMDR(err)
+	}
+	return r, total, dr
+}
+
+func (d *BaseSQLDAO[T]) CursorPaging(ctx context.Context, filter any, cursor CursorQuery, opts ...FindOption) ([]T, CursorPage, *MDR) {
+	plan, err := newCursorPlan(filter, cursor, opts)
+	if err != nil {
+		return nil, CursorPage{}, newErrMDR(err)
+	}
+
+	rows, dr := d.Find(ctx, plan.filter, plan.FindOptions()...)
+	if dr.Error != nil {
+		return nil, CursorPage{}, dr
+	}
+
+	rows, page, err := cursorResult(plan, rows)
+	if err != nil {
+		return nil, page, newErrMDR(err)
+	}
+
+	if !cursor.SkipTotal {
+		if page.Total, err = d.Count(ctx, filter); err != nil {
+			return nil, page, newErrMDR(err)
+		}
+	}
+	return rows, page, new(MDR).SetCount(int64(len(rows)))
+}
+
+// BulkWrite runs the operations in a transaction, each under a savepoint so
+// that a failed operation is rolled back alone and the others commit, like
+// Mongo. Ordered writes stop at the first failure and keep the operations
+// before it. When the commit fails nothing is written and every operation
+// reports the error.
+func (d *BaseSQLDAO[T]) BulkWrite(ctx context.Context, ops []BulkOp, ordered bool) *MDR {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return newErrMDR(err)
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	r := &MDR{Ops: make([]BulkOpResult, len(ops))}
+	for i, op := range ops {
+		res := BulkOpResult{Index: i}
+
+		var dr *MDR
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_op"); err != nil {
+			dr = newErrMDR(err)
+		} else {
+			dr = d.bulkOp(ctx, tx, i, op, &res)
+			release := "RELEASE SAVEPOINT bulk_op"
+			if dr.Error != nil {
+				// PostgreSQL aborts the transaction on an error until it is
+				// rolled back to the savepoint
+				release = "ROLLBACK TO SAVEPOINT bulk_op"
+				dr.Matched, dr.Modified, dr.Upserted, dr.Deleted = 0, 0, 0, 0
+				res.ID = ""
+			}
+			if _, err := tx.ExecContext(ctx, release); err != nil {
+				dr = newErrMDR(errors.Join(dr.Error, err))
+			}
+		}
+
+		if dr.Error == nil && op.Type == BulkInsert {
+			r.Inserted++
+		}
+		r.Matched += dr.Matched
+		r.Modified += dr.Modified
+		r.Upserted += dr.Upserted
+		r.Deleted += dr.Deleted
+		res.OK, res.Error = dr.Error == nil, dr.Error
+		r.Ops[i] = res
+
+		if dr.Error != nil {
+			r.Error = errors.Join(r.Error, fmt.Errorf("bulk: operation %d: %w", i, dr.Error))
+			if ordered {
+				for j := i + 1; j < len(ops); j++ {
+					r.Ops[j] = BulkOpResult{Index: j, Error: ErrBulkNotExecuted}
+				}
+				break
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		err = fmt.Errorf("bulk: commit: %w", err)
+		for i := range r.Ops {
+			if r.Ops[i].OK {
+				r.Ops[i] = BulkOpResult{Index: i, Error: err}
+			}
+		}
+		r.Inserted, r.Matched, r.Modified, r.Upserted, r.Deleted = 0, 0, 0, 0, 0
+		r.Error = errors.Join(r.Error, err)
+		return r
+	}
+
+	for _, op := range r.Ops {
+		if op.ID != "" {
+			r.IDs = append(r.IDs, op.ID)
+		}
+	}
+	r.Count = r.Inserted + r.Modified + r.Upserted + r.Deleted
+	return r
+}
+
+func (d *BaseSQLDAO[T]) bulkOp(ctx context.Context, tx *sql.Tx, i int, op BulkOp, res *BulkOpResult) *MDR {
+	switch op.Type {
+	case BulkInsert:
+		id, err := d.insert(ctx, tx, op.Model)
+		res.ID = id
+		return &MDR{Error: err}
+	case BulkUpdate:
+		return d.update(ctx, tx, op.Filter, op.Model, op.Many)
+	case BulkUpsert:
+		dr := d.update(ctx, tx, op.Filter, op.Model, op.Many)
+		if dr.Error == nil && dr.Count == 0 {
+			dr, res.ID = d.upsert(ctx, tx, op.Filter, op.Model)
+		}
+		return dr
+	case BulkDelete:
+		return d.delete(ctx, tx, op.Filter, op.Many)
+	}
+	return newErrMDR(fmt.Errorf("bulk: operation %d: unknown type %d", i, op.Type))
+}
+
+// upsert inserts the equality conditions of filter with the fields of model.
+func (d *BaseSQLDAO[T]) upsert(ctx context.Context, db sqlExecer, filter any, model any) (*MDR, string) {
+	f, err := toBsonM(filter)
+	if err != nil {
+		return newErrMDR(err), ""
+	}
+	set, err := toBsonM(model)
+	if err != nil {
+		return newErrMDR(err), ""
+	}
+
+	doc := bson.M{}
+	for k, v := range f {
+		if ops, ok := v.(bson.M); !strings.HasPrefix(k, "$") && (!ok || !isMgoOps(ops)) {
+			doc[k] = v
+		}
+	}
+	for k, v := range set {
+		doc[k] = v
+	}
+
+	id, err := d.insert(ctx, db, doc)
+	if err != nil {
+		return newErrMDR(err), ""
+	}
+	return &MDR{Count: 1, Upserted: 1}, id
+}
+
+// CreateIndexes creates the indexes on the columns of their keys, the
+// direction and the unique option are kept.
+func (d *BaseSQLDAO[T]) CreateIndexes(ctx context.Context, models []mongo.IndexModel) *MDR {
+	for _, m := range models {
+		keys, ok := m.Keys.(bson.D)
+		if !ok {
+			return newErrMDR(errors.New("sql: index keys must be a bson.D"))
+		}
+
+		var cols, names []string
+		for _, k := range keys {
+			name := sqlColumn(k.Key)
+			dir := " ASC"
+			if f, _ := toFloat(k.Value); f < 0 {
+				dir = " DESC"
+			}
+			cols = append(cols, d.Dialect.quote(name)+dir)
+			names = append(names, name)
+		}
+
+		unique := ""
+		indexName := d.Table + "_" + strings.Join(names, "_")
+		if m.Options != nil {
+			if m.Options.Unique != nil && *m.Options.Unique {
+				unique = "UNIQUE "
+			}
+			if m.Options.Name != nil {
+				indexName = *m.Options.Name
+			}
+		}
+
+		query := "CREATE " + unique + "INDEX "
+		if d.Dialect != DialectMySQL {
+			query += "IF NOT EXISTS "
+		}
+		query += d.Dialect.quote(indexName) + " ON " + d.Dialect.quote(d.Table) + " (" + strings.Join(cols, ", ") + ")"
+		if _, err := d.DB.ExecContext(ctx, query); err != nil {
+			return newErrMDR(err)
+		}
+	}
+	return new(MDR).SetCount(int64(len(models)))
+}
+
+func (d *BaseSQLDAO[T]) Aggregate(ctx context.Context, pipeline any, results any) *MDR {
+	return newErrMDR(errors.New("sql: Aggregate is not supported, query the database directly"))
+}
+
+// sqlScanValue converts a scanned column to the bson value decoded into a
+// field of type t.
+func sqlScanValue(v any, t reflect.Type) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return v, nil
+	}
+
+	switch {
+	case t == timeType:
+		switch x := v.(type) {
+		case time.Time:
+			return primitive.NewDateTimeFromTime(x), nil
+		case string:
+			tm, err := parseSQLTime(x)
+			if err != nil {
+				return nil, err
+			}
+			return primitive.NewDateTimeFromTime(tm), nil
+		}
+	case t.Kind() == reflect.Bool:
+		switch x := v.(type) {
+		case int64:
+			return x != 0, nil
+		case string:
+			return strconv.ParseBool(x)
+		}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseInt(s, 10, 64)
+		}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		switch x := v.(type) {
+		case int64:
+			return float64(x), nil
+		case string:
+			return strconv.ParseFloat(x, 64)
+		}
+	case t.Kind() == reflect.Struct || t.Kind() == reflect.Map ||
+		(t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Array:
+		if s, ok := v.(string); ok {
+			return sqlUnJSON(s)
+		}
+	case t.Kind() == reflect.Slice:
+		if s, ok := v.(string); ok {
+			return []byte(s), nil
+		}
+	}
+	return v, nil
+}
+
+var sqlTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func parseSQLTime(s string) (time.Time, error) {
+	for _, layout := range sqlTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
@@ -0,0 +1,177 @@
+package hin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	_ "modernc.org/sqlite"
+	"reflect"
+	"testing"
+)
+
+type sqlUserModel struct {
+	BaseModel `bson:",inline"`
+	Name      string   `bson:"name"`
+	Age       int      `bson:"age"`
+	Active    bool     `bson:"active"`
+	Tags      []string `bson:"tags"`
+}
+
+type sqlUser struct {
+	ID      HID
+	Name    string
+	Age     int
+	Active  bool
+	Tags    []string
+	Version int64
+}
+
+func TestCriteriaSql(t *testing.T) {
+	tests := []struct {
+		c       CriteriaBuilder
+		dialect SQLDialect
+		sql     string
+		args    []any
+	}{
+		{Criteria("name = ? and age >= ?", "ann", 18), DialectSQLite,
+			`"age" >= ? AND "deleted_at" IS NULL AND "name" = ?`, []any{int64(18), "ann"}},
+		{Where("id").In("a", "b").Or(Where("name").Ne("x")).WithDeleted(), DialectPostgres,
+			`((("id" IN ($1, $2))) OR (("name" <> $3 OR "name" IS NULL)))`, []any{"a", "b", "x"}},
+		{Where("name").Prefix("a_%").WithDeleted(), DialectSQLite, `"name" GLOB ?`, []any{"a_%*"}},
+		{Where("name").IContains("A_b").WithDeleted(), DialectMySQL, "LOWER(`name`) LIKE ? ESCAPE '!'", []any{"%a!_b%"}},
+		{Where("name").Suffix("x.y").WithDeleted(), DialectPostgres, `"name" LIKE $1 ESCAPE '!'`, []any{"%x.y"}},
+		{Where("name").Regex("^a+$").WithDeleted(), DialectPostgres, `"name" ~ $1`, []any{"^a+$"}},
+		{Criteria("not (age < ?)", 3).OnlyDeleted(), DialectSQLite,
+			`NOT COALESCE(("age" < ?), 1=0) AND "deleted_at" IS NOT NULL`, []any{int64(3)}},
+	}
+
+	for _, tt := range tests {
+		s, args, err := tt.c.Sql(tt.dialect)
+		if err != nil || s != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Sql() = %s %v %v, want %s %v", s, args, err, tt.sql, tt.args)
+		}
+	}
+
+	c := Where("name").Regex("^a+$")
+	if _, _, err := c.Sql(DialectSQLite); err == nil {
+		t.Error("a regular expression must fail on SQLite")
+	}
+	if _, err := sqlValue(bson.M{"c": make(chan int)}); err == nil {
+		t.Error("a document that cannot be encoded must fail")
+	}
+}
+
+func TestSQLDAO(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		created_at DATETIME,
+		updated_at DATETIME,
+		deleted_at DATETIME,
+		version INTEGER,
+		name TEXT,
+		age INTEGER,
+		active BOOLEAN,
+		tags TEXT
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dao := NewSQLDAO[sqlUserModel](nil, db, &SQLDAOOptions{Table: "users", Dialect: DialectSQLite})
+	repo := NewBaseRepository[sqlUserModel, sqlUser](dao, nil)
+
+	for i, name := range []string{"ann", "bob", "cat", "dan"} {
+		if dr := repo.Save(ctx, sqlUser{Name: name, Age: 20 + i, Active: i%2 == 0, Tags: []string{name, "x"}}); dr.Error != nil {
+			t.Fatalf("Save(%s) = %v", name, dr.Error)
+		}
+	}
+
+	ann, dr := repo.FindOne(ctx, Where("name").Eq("ann"))
+	if dr.Error != nil || ann.Age != 20 || !ann.Active || !reflect.DeepEqual(ann.Tags, []string{"ann", "x"}) || ann.Version != 1 {
+		t.Fatalf("FindOne() = %+v, %v", ann, dr.Error)
+	}
+
+	ann.Age = 30
+	if dr := repo.Save(ctx, ann); dr.Error != nil {
+		t.Fatalf("update: %v", dr.Error)
+	}
+	var e Error
+	if dr := repo.Save(ctx, ann); !errors.As(dr.Error, &e) || e.Code != ErrVersionConflict {
+		t.Errorf("stale update = %v", dr.Error)
+	}
+
+	users, total, dr := repo.Paging(ctx, Where("age").Gte(21).OrderBy("-age"), PagingQuery{Page: 0, Count: 2})
+	if dr.Error != nil || total != 4 || len(users) != 2 || users[0].Name != "ann" || users[1].Name != "dan" {
+		t.Errorf("Paging() = %+v, %d, %v", users, total, dr.Error)
+	}
+
+	if dr := repo.Remove(ctx, Where("name").IPrefix("B")); dr.Error != nil || dr.Count != 1 {
+		t.Fatalf("Remove() = %+v", dr)
+	}
+	if n := repo.Count(ctx, CriteriaBuilder{}); n != 3 {
+		t.Errorf("Count() after Remove = %d", n)
+	}
+	if n := repo.Count(ctx, CriteriaBuilder{}.OnlyDeleted()); n != 1 {
+		t.Errorf("Count() of deleted = %d", n)
+	}
+
+	active := Where("active").Eq(true)
+	rows, page, dr := dao.CursorPaging(ctx, active.Mgo(), CursorQuery{Count: 1}, WithSort("name"))
+	if dr.Error != nil || len(rows) != 1 || rows[0].Name != "ann" || !page.HasNext || page.Total != 2 {
+		t.Fatalf("CursorPaging() = %+v %+v %v", rows, page, dr.Error)
+	}
+	rows, _, _ = dao.CursorPaging(ctx, active.Mgo(), CursorQuery{After: page.Next, Count: 1}, WithSort("name"))
+	if len(rows) != 1 || rows[0].Name != "cat" {
+		t.Errorf("CursorPaging() after = %+v", rows)
+	}
+
+	dr = dao.BulkWrite(ctx, []BulkOp{
+		InsertOp(sqlUserModel{Name: "eve"}),
+		UpsertOp(bson.M{"_id": "u1"}, bson.M{"name": "uma"}),
+		UpdateOp(bson.M{"name": "eve"}, bson.M{"age": 50}),
+		DeleteOp(bson.M{"active": false}).All(),
+	}, true)
+	if dr.Error != nil || dr.Inserted != 1 || dr.Upserted != 1 || dr.Modified != 1 || dr.Deleted != 3 {
+		t.Errorf("BulkWrite() = %+v", dr)
+	}
+	if n, _ := dao.Count(ctx, nil); n != 3 {
+		t.Errorf("rows after BulkWrite = %d", n)
+	}
+
+	// the failed insert is rolled back to its savepoint, the others commit
+	dr = dao.BulkWrite(ctx, []BulkOp{
+		UpdateOp(bson.M{"_id": "u1"}, bson.M{"age": 7}),
+		InsertOp(bson.M{"_id": "u1", "name": "dup"}),
+		InsertOp(sqlUserModel{Name: "fay"}),
+	}, false)
+	if dr.Error == nil || dr.Modified != 1 || dr.Inserted != 1 || !dr.Ops[0].OK || dr.Ops[1].OK || !dr.Ops[2].OK {
+		t.Errorf("unordered BulkWrite() = %+v", dr)
+	}
+	if u, _ := dao.FindOne(ctx, bson.M{"_id": "u1"}); u.Age != 7 || u.Name != "uma" {
+		t.Errorf("row after unordered BulkWrite = %+v", u)
+	}
+	dr = dao.BulkWrite(ctx, []BulkOp{
+		InsertOp(sqlUserModel{Name: "gus"}),
+		InsertOp(bson.M{"_id": "u1", "name": "dup"}),
+		InsertOp(sqlUserModel{Name: "hal"}),
+	}, true)
+	if dr.Inserted != 1 || !dr.Ops[0].OK || dr.Ops[1].OK || !errors.Is(dr.Ops[2].Error, ErrBulkNotExecuted) {
+		t.Errorf("ordered BulkWrite() = %+v", dr)
+	}
+	if n, _ := dao.Count(ctx, nil); n != 5 {
+		t.Errorf("rows after failed BulkWrites = %d", n)
+	}
+
+	if _, dr := dao.Find(ctx, bson.M{"missing": 1}); dr.Error == nil {
+		t.Error("a filter on an unknown column must fail")
+	}
+}
@@ -0,0 +1,25 @@
+package hin
+
+import (
+	"errors"
+)
+
+var errStopIter = errors.New("stop iteration")
+
+// iterOf adapts a callback stream to a range over func iterator (Go 1.23).
+// The stream error is yielded once with a zero value, breaking out of the
+// loop stops the stream.
+func iterOf[T any](each func(fn func(T) error) error) func(yield func(T, error) bool) {
+	return func(yield func(T, error) bool) {
+		err := each(func(v T) error {
+			if !yield(v, nil) {
+				return errStopIter
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopIter) {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+type streamEntity struct {
+	ID   string
+	Rank int
+}
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	dao := &testDAO[cursorModel]{}
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		dao.Insert(ctx, cursorModel{ID: id, Rank: i, CreatedAt: now})
+	}
+	repo := NewBaseRepository[cursorModel, streamEntity](dao, nil)
+	filter := Where("rank").Gte(1).OrderBy("rank")
+
+	var ids string
+	err := repo.Each(ctx, filter, func(e streamEntity) error {
+		ids += e.ID
+		return nil
+	})
+	if err != nil || ids != "bcd" {
+		t.Fatalf("Each() = %s, %v", ids, err)
+	}
+
+	boom := errors.New("boom")
+	ids = ""
+	err = repo.Each(ctx, filter, func(e streamEntity) error {
+		ids += e.ID
+		return boom
+	})
+	if err != boom || ids != "b" {
+		t.Errorf("Each() stopping = %s, %v", ids, err)
+	}
+
+	ids = ""
+	repo.Iter(ctx, filter)(func(e streamEntity, err error) bool {
+		ids += e.ID
+		return len(ids) < 2
+	})
+	if ids != "bc" {
+		t.Errorf("Iter() with break = %s", ids)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	var got error
+	repo.Iter(cancelled, filter)(func(e streamEntity, err error) bool {
+		got = err
+		return true
+	})
+	if !errors.Is(got, context.Canceled) {
+		t.Errorf("Iter() on a cancelled context yielded %v", got)
+	}
+}
+
+func TestMongoStream(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	doc := func(id string, rank int) bson.D {
+		return bson.D{{Key: "_id", Value: id}, {Key: "rank", Value: rank}}
+	}
+	batches := func(mt *mtest.T) {
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch, doc("a", 0)),
+			mtest.CreateCursorResponse(1, ns, mtest.NextBatch, doc("b", 1)),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch, doc("c", 2)),
+		)
+	}
+
+	mt.Run("batches", func(mt *mtest.T) {
+		batches(mt)
+		dao := &BaseMongoDAO[cursorModel]{nil, mt.Client, mt.Coll, mt.DB}
+		var ids string
+		err := dao.Each(context.Background(), bson.M{}, func(m cursorModel) error {
+			ids += m.ID
+			return nil
+		}, WithBatchSize(1))
+		if err != nil || ids != "abc" {
+			mt.Fatalf("Each() = %s, %v", ids, err)
+		}
+		if size, err := mt.GetStartedEvent().Command.LookupErr("batchSize"); err != nil || size.Int32() != 1 {
+			mt.Errorf("find batchSize = %v, %v", size, err)
+		}
+		if mt.GetStartedEvent().CommandName != "getMore" {
+			mt.Error("Each() did not read the next batch")
+		}
+	})
+
+	mt.Run("cancel", func(mt *mtest.T) {
+		batches(mt)
+		dao := &BaseMongoDAO[cursorModel]{nil, mt.Client, mt.Coll, mt.DB}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var ids string
+		var got error
+		dao.Iter(ctx, bson.M{}, WithBatchSize(1))(func(m cursorModel, err error) bool {
+			ids += m.ID
+			got = err
+			cancel()
+			return true
+		})
+		if !errors.Is(got, context.Canceled) || ids != "a" {
+			mt.Errorf("Iter() after cancel = %s, %v", ids, got)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package hin
+
+import (
+	"context"
+)
+
+// TenantModel is embedded inline by models owned by a tenant. BaseRepo sets
+// TenantID when a model is created and scopes every filter to the tenant of
+// the context, an operation without a tenant fails with ErrTenantRequired.
+// Updates never change the tenant.
+type TenantModel struct {
+	TenantID string `json:"tenant_id" bson:"tenant_id,omitempty"`
+}
+
+type tenantKey struct{}
+
+type allTenantsKey struct{}
+
+// WithTenant sets the tenant of ctx, for jobs and consumers that run outside
+// of a request.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// AllTenants lifts the tenant scope of repositories for cross tenant admin
+// jobs. Saved models keep the tenant they carry.
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey{}, true)
+}
+
+func IsAllTenants(ctx context.Context) bool {
+	v, _ := ctx.Value(allTenantsKey{}).(bool)
+	return v
+}
+
+// TenantOf returns the tenant set by WithTenant, or else the tenant of the
+// JWT claims of the current request.
+func TenantOf(ctx context.Context) string {
+	if v, ok := ctx.Value(tenantKey{}).(string); ok {
+		return v
+	}
+	if c := GetCurrentContext(ctx); c != nil {
+		return c.Tenant()
+	}
+	return ""
+}
+
+// tenantOf returns the tenant M is scoped to in ctx, empty when M has no
+// tenant or ctx spans all tenants.
+func tenantOf[M any](ctx context.Context) (string, error) {
+	if !hasField[M]("TenantID") || IsAllTenants(ctx) {
+		return "", nil
+	}
+	if t := TenantOf(ctx); t != "" {
+		return t, nil
+	}
+	return "", NewError(nil, ErrTenantRequired)
+}
+
+// scopeTenant restricts filter to the tenant M is scoped to in ctx.
+func scopeTenant[M any](ctx context.Context, filter CriteriaBuilder) (CriteriaBuilder, error) {
+	t, err := tenantOf[M](ctx)
+	if err != nil || t == "" {
+		return filter, err
+	}
+	return filter.And(Where("tenant_id").Eq(t)), nil
+}
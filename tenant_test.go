@@ -0,0 +1,182 @@
+package hin
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type tenantModel struct {
+	BaseModel   `bson:",inline"`
+	TenantModel `bson:",inline"`
+	Name        string `bson:"name"`
+}
+
+type tenantEntity struct {
+	ID       HID
+	TenantID string
+	Name     string
+}
+
+func TestTenant(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[tenantModel]()
+	history := NewHistory(NewMemoryDAO[HistoryRecord](), nil)
+	repo := NewBaseRepository[tenantModel, tenantEntity](dao, &Logger{zap.NewNop()}).WithHistory(history)
+
+	var e Error
+	if dr := repo.Save(ctx, tenantEntity{Name: "orphan"}); !errors.As(dr.Error, &e) || e.Code != ErrTenantRequired {
+		t.Fatalf("Save() without tenant = %v", dr.Error)
+	}
+	if _, dr := repo.Find(ctx, Criteria(nil)); !errors.As(dr.Error, &e) || e.Code != ErrTenantRequired {
+		t.Fatalf("Find() without tenant = %v", dr.Error)
+	}
+
+	RegisterContext("req-a", &CurrentContext{Claims: &JwtClaims{TenantID: "a", RegisteredClaims: jwt.RegisteredClaims{Subject: "ann"}}})
+	defer UnRegisterContext("req-a")
+	aCtx := context.WithValue(ctx, headerXRequestID, "req-a")
+	bCtx := WithTenant(ctx, "b")
+
+	// the tenant of the context wins over the one of the entity
+	repo.Save(aCtx, tenantEntity{Name: "a1", TenantID: "b"})
+	repo.Save(aCtx, tenantEntity{Name: "a2"})
+	repo.Save(bCtx, tenantEntity{Name: "b1"})
+
+	if n := repo.Count(aCtx, Criteria(nil)); n != 2 {
+		t.Errorf("Count(a) = %d", n)
+	}
+	b1, dr := repo.FindOne(bCtx, Where("name").Eq("b1"))
+	if dr.Error != nil || b1.TenantID != "b" {
+		t.Fatalf("FindOne(b) = %+v, %v", b1, dr.Error)
+	}
+	if !repo.Exist(aCtx, Where("name").Eq("b1").Or(Where("name").Eq("a1"))) {
+		t.Error("a1 should exist for a")
+	}
+	if users, _ := repo.Find(aCtx, Where("name").Eq("b1").Or(Where("name").Eq("a1"))); len(users) != 1 || users[0].Name != "a1" {
+		t.Errorf("Find(a) = %+v", users)
+	}
+
+	b1.Name = "stolen"
+	if dr := repo.Save(aCtx, b1); !errors.As(dr.Error, &e) || e.Code != ErrNotFound {
+		t.Errorf("Save() of another tenant = %v", dr.Error)
+	}
+	repo.Remove(aCtx, Where("_id").Eq(b1.ID.String()))
+	if got, _ := repo.FindOne(bCtx, Where("_id").Eq(b1.ID.String())); got.Name != "b1" {
+		t.Errorf("b1 changed by a: %+v", got)
+	}
+
+	if n := repo.Count(AllTenants(ctx), Criteria(nil)); n != 3 {
+		t.Errorf("Count(all) = %d", n)
+	}
+
+	// an admin save keeps the tenant of the stored model
+	all := AllTenants(ctx)
+	for _, tenant := range []string{"", "a"} {
+		b1.Name, b1.TenantID = "b1 "+tenant, tenant
+		if dr := repo.Save(all, b1); dr.Error != nil {
+			t.Fatalf("Save(all) = %v", dr.Error)
+		}
+		if got, _ := repo.FindOne(bCtx, Where("_id").Eq(b1.ID.String())); got.Name != b1.Name || got.TenantID != "b" {
+			t.Errorf("b1 after Save(all) = %+v", got)
+		}
+	}
+
+	// the history is read by tenant
+	if records, _ := history.Of(bCtx, b1.ID.String()); len(records) != 3 || records[0].TenantID != "b" {
+		t.Errorf("Of(b) = %+v", records)
+	}
+	if records, _ := history.Of(aCtx, b1.ID.String()); len(records) != 0 {
+		t.Errorf("Of(a) of b1 = %+v", records)
+	}
+	if records, _ := history.Of(ctx, b1.ID.String()); len(records) != 0 {
+		t.Errorf("Of() without tenant of b1 = %+v", records)
+	}
+	if records, _ := history.Of(all, b1.ID.String()); len(records) != 3 {
+		t.Errorf("Of(all) of b1 = %+v", records)
+	}
+
+	// and so are aggregations
+	if names, dr := Distinct[string](aCtx, dao, CriteriaBuilder{}, "name"); dr.Error != nil || len(names) != 2 {
+		t.Errorf("Distinct(a) = %v, %v", names, dr.Error)
+	}
+	if _, dr := CountBy[string](ctx, dao, CriteriaBuilder{}, "name"); !errors.As(dr.Error, &e) || e.Code != ErrTenantRequired {
+		t.Errorf("CountBy() without tenant = %v", dr.Error)
+	}
+
+	cached := NewCachedRepository[tenantEntity](repo, NewLRUCache(10), &Logger{zap.NewNop()}, "tenants")
+	if n := cached.Count(aCtx, Criteria(nil)); n != 2 {
+		t.Errorf("cached Count(a) = %d", n)
+	}
+	if n := cached.Count(bCtx, Criteria(nil)); n != 1 {
+		t.Errorf("cached Count(b) = %d", n)
+	}
+}
+
+func TestTenantPurge(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[tenantModel]()
+	repo := NewBaseRepository[tenantModel, tenantEntity](dao, &Logger{zap.NewNop()})
+	for _, tenant := range []string{"a", "b"} {
+		tCtx := WithTenant(ctx, tenant)
+		repo.Save(tCtx, tenantEntity{Name: tenant})
+		repo.Remove(tCtx, Criteria(nil))
+	}
+
+	viper.Set("mongo.purge.retention", time.Nanosecond)
+	defer viper.Set("mongo.purge.retention", nil)
+	time.Sleep(time.Millisecond)
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	// purges once, then stops as ctx is done
+	RunPurge(done, &Logger{zap.NewNop()}, repo)
+	if n, _ := dao.Count(ctx, bson.M{}); n != 0 {
+		t.Errorf("%d documents left after RunPurge", n)
+	}
+}
+
+// plainTenantModel stores its tenant without omitempty.
+type plainTenantModel struct {
+	BaseModel `bson:",inline"`
+	TenantID  string `bson:"tenant_id"`
+	Name      string `bson:"name"`
+}
+
+type plainTenantEntity struct {
+	ID       HID
+	TenantID string
+	Name     string
+	Version  int64
+}
+
+func TestTenantUpdateKeepsTenant(t *testing.T) {
+	ctx := context.Background()
+	dao := NewMemoryDAO[plainTenantModel]()
+	repo := NewBaseRepository[plainTenantModel, plainTenantEntity](dao, &Logger{zap.NewNop()})
+	aCtx := WithTenant(ctx, "a")
+
+	dr := repo.Save(aCtx, plainTenantEntity{Name: "a1"})
+	if dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	id := dr.ID()
+
+	saves := []struct {
+		ctx     context.Context
+		version int64
+	}{{aCtx, 0}, {AllTenants(ctx), 0}, {aCtx, 1}, {AllTenants(ctx), 2}}
+	for i, s := range saves {
+		e, _ := repo.FindOne(AllTenants(ctx), Where("_id").Eq(id))
+		e.Name, e.TenantID, e.Version = "a1", "", s.version
+		if dr := repo.Save(s.ctx, e); dr.Error != nil {
+			t.Fatalf("save %d: %v", i, dr.Error)
+		}
+		if m, _ := dao.FindOne(ctx, bson.M{"_id": id}); m.TenantID != "a" {
+			t.Errorf("save %d: tenant_id = %q, want a", i, m.TenantID)
+		}
+	}
+}
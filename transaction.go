@@ -0,0 +1,120 @@
+package hin
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+)
+
+var txSupport sync.Map
+
+// WithTransaction runs fn in a Mongo transaction, every BaseMongoDAO method
+// called with txCtx takes part in it. The transaction is committed when fn
+// returns nil and aborted otherwise, transient transaction errors and unknown
+// commit results are retried by the driver, so fn must be safe to run again.
+//
+// A call inside a running transaction joins it, the functions registered by
+// AfterCommit run once the outermost transaction has committed. Standalone servers, which do
+// not support transactions, and mongo.transaction=false run fn directly. When
+// the server cannot be asked, the error is returned and fn does not run.
+func WithTransaction(ctx context.Context, client *mongo.Client, fn func(txCtx context.Context) error) error {
+	if InTransaction(ctx) {
+		return fn(ctx)
+	}
+	if ok, err := supportsTransaction(ctx, client); err != nil {
+		return err
+	} else if !ok {
+		return fn(ctx)
+	}
+
+	sess, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	hooks := &commitHooks{}
+	ctx = context.WithValue(ctx, commitHooksKey{}, hooks)
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		// a retried transaction registers its hooks again
+		hooks.reset()
+		return nil, fn(sc)
+	})
+	if err == nil {
+		hooks.run()
+	}
+	return err
+}
+
+type commitHooksKey struct{}
+
+type commitHooks struct {
+	mux sync.Mutex
+	fns []func()
+}
+
+func (h *commitHooks) add(fn func()) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.fns = append(h.fns, fn)
+}
+
+func (h *commitHooks) reset() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.fns = nil
+}
+
+func (h *commitHooks) run() {
+	h.mux.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mux.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// AfterCommit runs fn after the transaction started by WithTransaction in ctx
+// has committed, it does not run when the transaction is aborted. Outside of
+// such a transaction fn runs at once.
+func AfterCommit(ctx context.Context, fn func()) {
+	if h, ok := ctx.Value(commitHooksKey{}).(*commitHooks); ok && InTransaction(ctx) {
+		h.add(fn)
+		return
+	}
+	fn()
+}
+
+// InTransaction reports whether ctx carries a session started by WithTransaction.
+func InTransaction(ctx context.Context) bool {
+	return mongo.SessionFromContext(ctx) != nil
+}
+
+// supportsTransaction asks the server once per client whether it is a replica
+// set member or a mongos, the deployments that support transactions.
+func supportsTransaction(ctx context.Context, client *mongo.Client) (bool, error) {
+	if viper.IsSet("mongo.transaction") && !viper.GetBool("mongo.transaction") {
+		return false, nil
+	}
+
+	if v, ok := txSupport.Load(client); ok {
+		return v.(bool), nil
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		// do not cache, the server may be unreachable for the moment
+		return false, err
+	}
+
+	supported := hello.SetName != "" || hello.Msg == "isdbgrid"
+	txSupport.Store(client, supported)
+	return supported, nil
+}
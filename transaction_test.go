@@ -0,0 +1,60 @@
+package hin
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+func TestWithTransaction(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	// an unreachable server is an error, fn must not run without atomicity
+	ran := false
+	if err := WithTransaction(ctx, client, func(context.Context) error {
+		ran = true
+		return nil
+	}); err == nil || ran {
+		t.Errorf("WithTransaction() on an unreachable server = %v, ran %v", err, ran)
+	}
+
+	// a running transaction is joined
+	sess, err := client.StartSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.EndSession(ctx)
+	sc := mongo.NewSessionContext(ctx, sess)
+	if !InTransaction(sc) || InTransaction(ctx) {
+		t.Fatal("InTransaction() does not see the session")
+	}
+	if err := WithTransaction(sc, client, func(txCtx context.Context) error {
+		if txCtx != sc {
+			t.Error("a nested call does not join the running transaction")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("nested WithTransaction() = %v", err)
+	}
+
+	// mongo.transaction=false runs fn directly
+	viper.Set("mongo.transaction", false)
+	defer viper.Set("mongo.transaction", nil)
+	ran = false
+	if err := WithTransaction(ctx, client, func(txCtx context.Context) error {
+		ran = !InTransaction(txCtx)
+		return nil
+	}); err != nil || !ran {
+		t.Errorf("WithTransaction() with transactions disabled = %v, ran %v", err, ran)
+	}
+}
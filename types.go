@@ -38,6 +38,9 @@ type BaseModel struct {
 	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" bson:"updated_at"`
 	DeletedAt *time.Time `json:"deleted_at" bson:"deleted_at,omitempty"`
+	// Version enables optimistic locking when the entity carries it back to
+	// Save, a zero version updates without the check.
+	Version int64 `json:"version" bson:"version,omitempty"`
 }
 
 type IdentityQuery struct {
@@ -55,3 +58,22 @@ type PagingDTO struct {
 	Total int64 `json:"total"`
 	Items any   `json:"items"`
 }
+
+// CursorQuery reads the page after or before an opaque cursor taken from a
+// CursorPagingDTO, without either the first page is read.
+type CursorQuery struct {
+	After     string `form:"after"`
+	Before    string `form:"before"`
+	Count     int64  `form:"count,default=20"`
+	SkipTotal bool   `form:"skip_total"`
+}
+
+type CursorPagingDTO struct {
+	Count   int64  `json:"count"`
+	Total   *int64 `json:"total,omitempty"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+	HasNext bool   `json:"has_next"`
+	HasPrev bool   `json:"has_prev"`
+	Items   any    `json:"items"`
+}